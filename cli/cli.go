@@ -0,0 +1,82 @@
+// Package cli implements the companion commands of the driver binary. They are
+// available when the binary is run directly rather than as a rancher-machine plugin.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// command is a companion subcommand of the driver binary
+type command struct {
+	name  string
+	usage string
+	run   func(args []string, stdout io.Writer) error
+}
+
+func commands() []command {
+	return []command{
+		{"resize", "Change the flavor of a machine in place", runResize},
+		{"extend-volume", "Grow the system or data volume of a machine and its filesystem", runExtendVolume},
+		{"gc", "Report or terminate resources of machines Rancher lost track of", runGC},
+		{"discover", "List the Waldur objects the driver flags refer to, with example flags", runDiscover},
+		{"template", "Generate a validated Rancher machine config or node template", runTemplate},
+		{"ui", "Print the Rancher UI metadata of the driver fields or its NodeDriver resource", runUI},
+		{"serve", "Serve the driver field options to a Rancher custom UI component", runServe},
+	}
+}
+
+// Run executes the subcommand named by the first argument and returns the exit code
+func Run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stdout)
+		return 0
+	}
+
+	for _, cmd := range commands() {
+		if cmd.name != args[0] {
+			continue
+		}
+		err := cmd.run(args[1:], stdout)
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(stderr, "Unknown command %q\n\n", args[0])
+	printUsage(stderr)
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Waldur node driver for rancher-machine.")
+	fmt.Fprintln(w, "Run by rancher-machine as a plugin, or directly with one of the commands:")
+	fmt.Fprintln(w)
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-14s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run '<command> -h' for the options of a command.")
+}
+
+// newFlagSet returns a flag set for the command which reports parse errors instead of exiting
+func newFlagSet(name string, stdout io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stdout)
+	return flags
+}
+
+// addConnectionFlags registers the Waldur API options of the commands not operating on a stored machine
+func addConnectionFlags(flags *flag.FlagSet) (apiUrl, apiToken *string) {
+	apiUrl = flags.String("api-url", os.Getenv("WALDUR_API_URL"), "Waldur API URL (WALDUR_API_URL)")
+	apiToken = flags.String("api-token", os.Getenv("WALDUR_API_TOKEN"), "Waldur API token (WALDUR_API_TOKEN)")
+	return apiUrl, apiToken
+}
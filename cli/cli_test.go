@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeMachine stores a machine config as rancher-machine does and returns the storage path
+func writeMachine(t *testing.T, name, driverName string, driverConfig map[string]any) string {
+	t.Helper()
+	storagePath := t.TempDir()
+	dir := filepath.Join(storagePath, "machines", name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(map[string]any{
+		"ConfigVersion": 3,
+		"Driver":        driverConfig,
+		"DriverName":    driverName,
+		"HostOptions":   map[string]any{"Driver": ""},
+		"Name":          name,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, machineConfigFile), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return storagePath
+}
+
+func TestRunUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := Run(nil, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), "resize") {
+		t.Errorf("unexpected usage, code %d: %s", code, stdout.String())
+	}
+	if code := Run([]string{"explode"}, &stdout, &stderr); code != 2 || !strings.Contains(stderr.String(), `Unknown command "explode"`) {
+		t.Errorf("unexpected unknown command handling, code %d: %s", code, stderr.String())
+	}
+}
+
+func TestLoadAndSaveMachine(t *testing.T) {
+	storagePath := writeMachine(t, "worker1", "waldur", map[string]any{
+		"MachineName": "worker1",
+		"ApiUrl":      "https://waldur.example.com",
+		"FlavorUuid":  "old",
+	})
+
+	m, err := loadMachine(storagePath, "worker1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Driver.ApiUrl != "https://waldur.example.com" || m.Driver.GetMachineName() != "worker1" {
+		t.Fatalf("unexpected driver %+v", m.Driver)
+	}
+	m.Driver.FlavorUuid = "new"
+	if err := m.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := loadMachine(storagePath, "worker1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Driver.FlavorUuid != "new" {
+		t.Errorf("flavor was not saved, got %s", saved.Driver.FlavorUuid)
+	}
+	hostOptions := map[string]any{}
+	if err := json.Unmarshal(saved.host["HostOptions"], &hostOptions); err != nil || hostOptions["Driver"] != "" {
+		t.Errorf("host options were not preserved: %s", saved.host["HostOptions"])
+	}
+}
+
+func TestLoadMachineOfOtherDriver(t *testing.T) {
+	storagePath := writeMachine(t, "vm", "openstack", map[string]any{})
+	if _, err := loadMachine(storagePath, "vm"); err == nil || !strings.Contains(err.Error(), "not managed by the Waldur driver") {
+		t.Fatalf("expected driver mismatch error, got %v", err)
+	}
+	if _, err := loadMachine(storagePath, "missing"); err == nil {
+		t.Fatal("expected an error for a missing machine")
+	}
+}
+
+func TestResizeRequiresFlavor(t *testing.T) {
+	storagePath := writeMachine(t, "worker1", "waldur", map[string]any{})
+	var stdout, stderr bytes.Buffer
+	code := Run([]string{"resize", "-storage-path", storagePath, "worker1"}, &stdout, &stderr)
+	if code != 1 || !strings.Contains(stderr.String(), "-flavor-uuid") {
+		t.Errorf("unexpected result, code %d: %s", code, stderr.String())
+	}
+}
+
+func TestExtendVolumeRequiresSize(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := Run([]string{"extend-volume", "-volume", "data", "worker1"}, &stdout, &stderr)
+	if code != 1 || !strings.Contains(stderr.String(), "-size") {
+		t.Errorf("unexpected result, code %d: %s", code, stderr.String())
+	}
+}
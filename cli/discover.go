@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+)
+
+// inventoryFlags maps the inventory kinds to the driver flags taking their UUIDs
+var inventoryFlags = map[string][]string{
+	driver.InventoryProjects:       {"waldur-proj-uuid"},
+	driver.InventoryOfferings:      {"waldur-offering-uuid"},
+	driver.InventoryFlavors:        {"waldur-flavor-uuid"},
+	driver.InventoryImages:         {"waldur-image-uuid"},
+	driver.InventoryVolumeTypes:    {"waldur-sys-volume-type-uuid", "waldur-data-volume-type-uuid"},
+	driver.InventorySubnets:        {"waldur-subnet-uuids"},
+	driver.InventorySecurityGroups: {"waldur-sec-group-uuid"},
+}
+
+// exampleFlag is a driver flag with a value taken from the inventory
+type exampleFlag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// exampleFlags builds driver flags from the first object of each listed kind, the
+// configured offering is preferred. The token is referenced rather than printed.
+func exampleFlags(apiUrl, offeringUuid string, inventory map[string][]driver.InventoryItem) []exampleFlag {
+	flags := []exampleFlag{
+		{Name: "waldur-api-url", Value: apiUrl},
+		{Name: "waldur-api-token", Value: "$WALDUR_API_TOKEN"},
+	}
+	for _, kind := range driver.InventoryKinds {
+		value := ""
+		if items := inventory[kind]; len(items) > 0 {
+			value = items[0].Uuid
+		}
+		if kind == driver.InventoryOfferings && offeringUuid != "" {
+			value = offeringUuid
+		}
+		if value == "" {
+			continue
+		}
+		for _, name := range inventoryFlags[kind] {
+			flags = append(flags, exampleFlag{Name: name, Value: value})
+		}
+	}
+	return flags
+}
+
+// printInventory writes a table of each listed kind followed by the example flags
+func printInventory(w io.Writer, kinds []string, inventory map[string][]driver.InventoryItem, flags []exampleFlag) error {
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "%s:\n", strings.ToUpper(kind[:1])+strings.ReplaceAll(kind[1:], "-", " "))
+		if len(inventory[kind]) == 0 {
+			fmt.Fprintln(w, "  none found")
+			fmt.Fprintln(w)
+			continue
+		}
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "  UUID\tNAME\tDETAILS")
+		for _, item := range inventory[kind] {
+			fmt.Fprintf(table, "  %s\t%s\t%s\n", item.Uuid, item.Name, item.Details)
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "Example driver flags, using the first object of each kind:")
+	for i, flag := range flags {
+		separator := " \\"
+		if i == len(flags)-1 {
+			separator = ""
+		}
+		fmt.Fprintf(w, "  --%s %s%s\n", flag.Name, flag.Value, separator)
+	}
+	return nil
+}
+
+// runDiscover lists the projects, offerings and tenant objects reachable with the token
+func runDiscover(args []string, stdout io.Writer) error {
+	flags := newFlagSet("discover", stdout)
+	apiUrl, apiToken := addConnectionFlags(flags)
+	offeringUuid := flags.String("offering-uuid", os.Getenv("WALDUR_OFFERING_UUID"), "UUID of the offering whose tenant objects are listed (WALDUR_OFFERING_UUID)")
+	asJSON := flags.Bool("json", false, "Print JSON instead of tables")
+	flags.Usage = func() {
+		fmt.Fprintln(stdout, "Usage: discover [options] [KIND...]")
+		fmt.Fprintf(stdout, "List the Waldur objects of the kinds, all by default: %s.\n", strings.Join(driver.InventoryKinds, ", "))
+		fmt.Fprintln(stdout, "Objects of the offering's tenant are listed when -offering-uuid is set.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *apiUrl == "" || *apiToken == "" {
+		return fmt.Errorf("discover requires the -api-url and -api-token options")
+	}
+
+	kinds := flags.Args()
+	if len(kinds) == 0 {
+		kinds = []string{driver.InventoryProjects, driver.InventoryOfferings}
+		if *offeringUuid != "" {
+			kinds = driver.InventoryKinds
+		}
+	}
+	for _, kind := range kinds {
+		if !slices.Contains(driver.InventoryKinds, kind) {
+			return fmt.Errorf("unknown kind %q, expected one of %s", kind, strings.Join(driver.InventoryKinds, ", "))
+		}
+	}
+
+	d := driver.NewDriver("discover", defaultStoragePath())
+	d.ApiUrl = *apiUrl
+	d.ApiToken = *apiToken
+	d.OfferingUuid = *offeringUuid
+	inventory := map[string][]driver.InventoryItem{}
+	for _, kind := range kinds {
+		items, err := d.ListInventory(kind)
+		if err != nil {
+			return fmt.Errorf("unable to list %s: %w", kind, err)
+		}
+		inventory[kind] = items
+	}
+	example := exampleFlags(*apiUrl, *offeringUuid, inventory)
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(map[string]any{
+			"inventory": inventory,
+			"flags":     example,
+		})
+	}
+	return printInventory(stdout, kinds, inventory, example)
+}
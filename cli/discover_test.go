@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+)
+
+func TestExampleFlags(t *testing.T) {
+	inventory := map[string][]driver.InventoryItem{
+		driver.InventoryProjects:    {{Uuid: "p1", Name: "k8s"}, {Uuid: "p2", Name: "ml"}},
+		driver.InventoryOfferings:   {{Uuid: "o1"}},
+		driver.InventoryVolumeTypes: {{Uuid: "v1", Name: "ssd"}},
+	}
+
+	flags := exampleFlags("https://waldur.example.com/", "o2", inventory)
+	values := map[string]string{}
+	for _, flag := range flags {
+		values[flag.Name] = flag.Value
+	}
+	expected := map[string]string{
+		"waldur-api-url":               "https://waldur.example.com/",
+		"waldur-api-token":             "$WALDUR_API_TOKEN",
+		"waldur-proj-uuid":             "p1",
+		"waldur-offering-uuid":         "o2",
+		"waldur-sys-volume-type-uuid":  "v1",
+		"waldur-data-volume-type-uuid": "v1",
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("unexpected flags %v", flags)
+	}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("flag %s is %q, expected %q", name, values[name], value)
+		}
+	}
+}
+
+func TestPrintInventory(t *testing.T) {
+	var stdout bytes.Buffer
+	inventory := map[string][]driver.InventoryItem{
+		driver.InventoryFlavors: {{Uuid: "f1", Name: "m1.small", Details: "1 vCPU, 2048 MB RAM"}},
+	}
+	kinds := []string{driver.InventoryFlavors, driver.InventoryImages}
+	if err := printInventory(&stdout, kinds, inventory, exampleFlags("https://waldur.example.com/", "", inventory)); err != nil {
+		t.Fatal(err)
+	}
+	output := stdout.String()
+	for _, expected := range []string{"Flavors:", "m1.small", "Images:\n  none found", "--waldur-flavor-uuid f1\n"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("output lacks %q:\n%s", expected, output)
+		}
+	}
+	if !strings.Contains(output, "--waldur-api-token $WALDUR_API_TOKEN") {
+		t.Errorf("output should reference the token variable:\n%s", output)
+	}
+}
+
+func TestDiscoverRejectsUnknownKind(t *testing.T) {
+	var stdout bytes.Buffer
+	err := runDiscover([]string{"-api-url", "https://waldur.example.com/", "-api-token", "token", "keypairs"}, &stdout)
+	if err == nil || !strings.Contains(err.Error(), `unknown kind "keypairs"`) {
+		t.Fatalf("expected an unknown kind error, got %v", err)
+	}
+}
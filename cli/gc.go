@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+)
+
+// rancherNameFields are the fields of Rancher API export items which hold machine names
+var rancherNameFields = []string{"name", "nodeName", "hostname", "requestedHostname"}
+
+// readMachineNames reads live machine names from a file: a Rancher API export, i.e. a JSON
+// collection with a data array of nodes or machines, or else one name per line
+func readMachineNames(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read machine list: %w", err)
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		export := struct {
+			Data []map[string]any `json:"data"`
+		}{}
+		if err := json.Unmarshal(trimmed, &export); err != nil {
+			return nil, fmt.Errorf("invalid Rancher export %s: %w", path, err)
+		}
+		names := []string{}
+		for _, item := range export.Data {
+			for _, field := range rancherNameFields {
+				if name, ok := item[field].(string); ok && name != "" {
+					names = append(names, name)
+				}
+			}
+			// Kubernetes style exports keep the name in the metadata
+			if metadata, ok := item["metadata"].(map[string]any); ok {
+				if name, ok := metadata["name"].(string); ok && name != "" {
+					names = append(names, name)
+				}
+			}
+		}
+		return names, nil
+	}
+
+	names := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" && !strings.HasPrefix(name, "#") {
+			names = append(names, name)
+		}
+	}
+	return names, scanner.Err()
+}
+
+// storedMachineNames returns the names of the machines in the rancher-machine store
+func storedMachineNames(storagePath string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(storagePath, "machines"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to list stored machines: %w", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// defaultGCGracePeriod is how old a resource must be before gc considers it, so that machines
+// being created, whose names may not be live yet, are left alone
+const defaultGCGracePeriod = time.Hour
+
+// findOrphans returns the managed resources of the cluster, all clusters when empty, or of no
+// cluster when unclustered, whose machine is not live. Only stable resources in the OK state created more than the grace
+// period before now are considered: creating, updating, erred and terminating resources are
+// still handled by rancher-machine or need a look by the operator.
+func findOrphans(resources []driver.ManagedResource, live map[string]bool, cluster string, unclustered bool, now time.Time, grace time.Duration) []driver.ManagedResource {
+	orphans := []driver.ManagedResource{}
+	for _, resource := range resources {
+		if resource.State != "OK" || resource.Created.IsZero() || now.Sub(resource.Created) < grace {
+			continue
+		}
+		if unclustered && resource.Cluster != "" {
+			continue
+		}
+		if cluster != "" && resource.Cluster != cluster {
+			continue
+		}
+		if !live[resource.Machine] {
+			orphans = append(orphans, resource)
+		}
+	}
+	return orphans
+}
+
+// runGC reports, or with -terminate terminates, the resources created by the driver whose machine is gone
+func runGC(args []string, stdout io.Writer) error {
+	flags := newFlagSet("gc", stdout)
+	storagePath := addStoragePathFlag(flags)
+	apiUrl, apiToken := addConnectionFlags(flags)
+	projectUuid := flags.String("project-uuid", os.Getenv("WALDUR_PROJ_UUID"), "UUID of the project to inspect (WALDUR_PROJ_UUID)")
+	cluster := flags.String("cluster", "", "Only consider the resources of this cluster, required with -terminate unless -without-cluster is set")
+	withoutCluster := flags.Bool("without-cluster", false, "Only consider the resources created without --waldur-cluster-name, so that -terminate can collect them")
+	machines := flags.String("machines", "", "Comma separated names of the live machines")
+	machinesFile := flags.String("machines-file", "", "File with the live machines: a Rancher API export of nodes or machines, or one name per line")
+	useStore := flags.Bool("use-store", true, "Count the machines of the rancher-machine store as live")
+	grace := flags.Duration("grace", defaultGCGracePeriod, "Only consider the resources created longer ago than this")
+	terminate := flags.Bool("terminate", false, "Terminate the orphans instead of only reporting them, including the instances adopted with --waldur-import-resource-uuid")
+	flags.Usage = func() {
+		fmt.Fprintln(stdout, "Usage: gc [options]")
+		fmt.Fprintln(stdout, "Find resources created by the driver whose machine is no longer known and terminate them with -terminate.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("gc accepts no arguments")
+	}
+	if *apiUrl == "" || *apiToken == "" || *projectUuid == "" {
+		return fmt.Errorf("gc requires the -api-url, -api-token and -project-uuid options")
+	}
+	if *cluster != "" && *withoutCluster {
+		return fmt.Errorf("gc accepts either -cluster or -without-cluster")
+	}
+	if *terminate && *cluster == "" && !*withoutCluster {
+		// The live machines come from one Rancher, the project may hold the nodes of other
+		// clusters, so collecting resources without a cluster is an explicit choice
+		return fmt.Errorf("gc requires the -cluster or -without-cluster option with -terminate")
+	}
+
+	live := map[string]bool{}
+	for _, name := range strings.Split(*machines, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			live[name] = true
+		}
+	}
+	if *machinesFile != "" {
+		names, err := readMachineNames(*machinesFile)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			live[name] = true
+		}
+	}
+	if *useStore {
+		names, err := storedMachineNames(*storagePath)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			live[name] = true
+		}
+	}
+	if len(live) == 0 {
+		// Without live machines every managed resource would be an orphan, which is rarely intended
+		return fmt.Errorf("gc requires live machines from -machines, -machines-file or the store")
+	}
+
+	d := driver.NewDriver("gc", *storagePath)
+	d.ApiUrl = *apiUrl
+	d.ApiToken = *apiToken
+	d.ProjectUuid = *projectUuid
+	resources, err := d.ListManagedResources()
+	if err != nil {
+		return err
+	}
+	orphans := findOrphans(resources, live, *cluster, *withoutCluster, time.Now(), *grace)
+	if len(orphans) == 0 {
+		fmt.Fprintf(stdout, "No orphans among %d resources created by the driver\n", len(resources))
+		return nil
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "RESOURCE\tNAME\tMACHINE\tCLUSTER\tNODE POOL\tSTATE")
+	for _, orphan := range orphans {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", orphan.Uuid, orphan.Name, orphan.Machine, orphan.Cluster, orphan.NodePool, orphan.State)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if !*terminate {
+		fmt.Fprintf(stdout, "Dry run: %d orphans found, rerun with -terminate to terminate them\n", len(orphans))
+		return nil
+	}
+	failed := 0
+	for _, orphan := range orphans {
+		if err := d.TerminateResource(orphan.Uuid); err != nil {
+			fmt.Fprintf(stdout, "Unable to terminate %s (%s): %v\n", orphan.Name, orphan.Uuid, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(stdout, "Terminated %s (%s)\n", orphan.Name, orphan.Uuid)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d orphans could not be terminated", failed, len(orphans))
+	}
+	return nil
+}
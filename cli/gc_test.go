@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+)
+
+func TestReadMachineNames(t *testing.T) {
+	dir := t.TempDir()
+	export := filepath.Join(dir, "nodes.json")
+	if err := os.WriteFile(export, []byte(`{"type": "collection", "data": [
+		{"id": "c-1:m-1", "requestedHostname": "worker-1"},
+		{"id": "c-1:m-2", "nodeName": "worker-2"},
+		{"metadata": {"name": "worker-3"}}
+	]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	names, err := readMachineNames(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"worker-1", "worker-2", "worker-3"}) {
+		t.Errorf("unexpected names from export %v", names)
+	}
+
+	list := filepath.Join(dir, "machines.txt")
+	if err := os.WriteFile(list, []byte("# live machines\nworker-1\n\n  worker-2  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	names, err = readMachineNames(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"worker-1", "worker-2"}) {
+		t.Errorf("unexpected names from list %v", names)
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	resources := []driver.ManagedResource{
+		{Uuid: "1", Machine: "worker-1", Cluster: "prod", State: "OK", Created: old},
+		{Uuid: "2", Machine: "worker-2", Cluster: "prod", State: "OK", Created: old},
+		{Uuid: "3", Machine: "worker-3", Cluster: "prod", State: "Terminating", Created: old},
+		{Uuid: "4", Machine: "test-1", Cluster: "test", State: "OK", Created: old},
+		{Uuid: "5", Machine: "node-1", State: "OK", Created: old},
+	}
+	live := map[string]bool{"worker-1": true}
+
+	if orphans := findOrphans(resources, live, "", false, now, time.Hour); len(orphans) != 3 || orphans[0].Uuid != "2" || orphans[1].Uuid != "4" || orphans[2].Uuid != "5" {
+		t.Errorf("unexpected orphans %+v", orphans)
+	}
+	if orphans := findOrphans(resources, live, "prod", false, now, time.Hour); len(orphans) != 1 || orphans[0].Uuid != "2" {
+		t.Errorf("unexpected orphans of cluster prod %+v", orphans)
+	}
+	if orphans := findOrphans(resources, live, "", true, now, time.Hour); len(orphans) != 1 || orphans[0].Uuid != "5" {
+		t.Errorf("unexpected orphans without a cluster %+v", orphans)
+	}
+}
+
+func TestFindOrphansSkipsInFlightResources(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	// None of the machines is live yet, as with a node pool being scaled up
+	resources := []driver.ManagedResource{
+		{Uuid: "1", Machine: "worker-1", Cluster: "prod", State: "Creating", Created: old},
+		{Uuid: "2", Machine: "worker-2", Cluster: "prod", State: "Updating", Created: old},
+		{Uuid: "3", Machine: "worker-3", Cluster: "prod", State: "Erred", Created: old},
+		{Uuid: "4", Machine: "worker-4", Cluster: "prod", State: "OK", Created: now.Add(-time.Minute)},
+		{Uuid: "5", Machine: "worker-5", Cluster: "prod", State: "OK"},
+	}
+	if orphans := findOrphans(resources, map[string]bool{}, "prod", false, now, time.Hour); len(orphans) != 0 {
+		t.Errorf("in-flight resources reported as orphans %+v", orphans)
+	}
+}
+
+func TestGCRequiresClusterToTerminate(t *testing.T) {
+	var stdout bytes.Buffer
+	err := runGC([]string{"-api-url", "https://waldur.example.com", "-api-token", "token", "-project-uuid", "p", "-machines", "worker-1", "-terminate"}, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "-cluster or -without-cluster option with -terminate") {
+		t.Fatalf("expected a cluster error, got %v", err)
+	}
+	err = runGC([]string{"-api-url", "https://waldur.example.com", "-api-token", "token", "-project-uuid", "p", "-cluster", "prod", "-without-cluster"}, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "either -cluster or -without-cluster") {
+		t.Fatalf("expected an exclusive options error, got %v", err)
+	}
+}
+
+func TestGCRequiresLiveMachines(t *testing.T) {
+	var stdout bytes.Buffer
+	err := runGC([]string{"-api-url", "https://waldur.example.com", "-api-token", "token", "-project-uuid", "p", "-storage-path", t.TempDir()}, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "requires live machines") {
+		t.Fatalf("expected a live machines error, got %v", err)
+	}
+}
+
+func TestGCRequiresConnection(t *testing.T) {
+	t.Setenv("WALDUR_API_URL", "")
+	t.Setenv("WALDUR_API_TOKEN", "")
+	t.Setenv("WALDUR_PROJ_UUID", "")
+	var stdout bytes.Buffer
+	err := runGC([]string{"-machines", "worker-1"}, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "-api-url, -api-token and -project-uuid") {
+		t.Fatalf("expected a connection options error, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+)
+
+// machineConfigFile is the file rancher-machine stores the host and driver config in
+const machineConfigFile = "config.json"
+
+// defaultStoragePath returns the rancher-machine storage path, following its MACHINE_STORAGE_PATH convention
+func defaultStoragePath() string {
+	if path := os.Getenv("MACHINE_STORAGE_PATH"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".docker", "machine")
+	}
+	return filepath.Join(home, ".docker", "machine")
+}
+
+// addStoragePathFlag registers the storage path option shared by the commands operating on machines
+func addStoragePathFlag(flags *flag.FlagSet) *string {
+	return flags.String("storage-path", defaultStoragePath(), "rancher-machine storage path (MACHINE_STORAGE_PATH)")
+}
+
+// machine is the config of a Waldur machine in the rancher-machine store
+type machine struct {
+	path string
+	// host holds the host config with the driver config left raw, so that saving preserves unknown fields
+	host   map[string]json.RawMessage
+	Driver *driver.Driver
+}
+
+// loadMachine reads the config of the named machine from the store
+func loadMachine(storagePath, name string) (*machine, error) {
+	path := filepath.Join(storagePath, "machines", name, machineConfigFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config of machine %s: %w", name, err)
+	}
+
+	m := &machine{path: path, host: map[string]json.RawMessage{}}
+	if err := json.Unmarshal(data, &m.host); err != nil {
+		return nil, fmt.Errorf("invalid config of machine %s: %w", name, err)
+	}
+	var driverName string
+	if err := json.Unmarshal(m.host["DriverName"], &driverName); err != nil || driverName != "waldur" {
+		return nil, fmt.Errorf("machine %s is not managed by the Waldur driver", name)
+	}
+	m.Driver = driver.NewDriver(name, storagePath)
+	if err := json.Unmarshal(m.host["Driver"], m.Driver); err != nil {
+		return nil, fmt.Errorf("invalid driver config of machine %s: %w", name, err)
+	}
+	return m, nil
+}
+
+// save writes the driver config back to the store
+func (m *machine) save() error {
+	driverJSON, err := json.Marshal(m.Driver)
+	if err != nil {
+		return err
+	}
+	m.host["Driver"] = driverJSON
+	data, err := json.MarshalIndent(m.host, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0o600)
+}
+
+// updateMachine runs the action on the driver of the named machine and saves the updated driver config
+func updateMachine(storagePath, name string, action func(d *driver.Driver) error) error {
+	m, err := loadMachine(storagePath, name)
+	if err != nil {
+		return err
+	}
+	if err := action(m.Driver); err != nil {
+		return err
+	}
+	if err := m.save(); err != nil {
+		return fmt.Errorf("machine %s was updated but its config could not be saved: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+)
+
+// runResize changes the flavor of a machine and persists it in the machine config
+func runResize(args []string, stdout io.Writer) error {
+	flags := newFlagSet("resize", stdout)
+	storagePath := addStoragePathFlag(flags)
+	flavorUuid := flags.String("flavor-uuid", "", "UUID of the new flavor in Waldur")
+	flags.Usage = func() {
+		fmt.Fprintln(stdout, "Usage: resize [options] MACHINE")
+		fmt.Fprintln(stdout, "Change the flavor of a machine, stopping it for the resize if it is running.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("resize requires exactly one machine name")
+	}
+	if *flavorUuid == "" {
+		return fmt.Errorf("resize requires the -flavor-uuid option")
+	}
+
+	err := updateMachine(*storagePath, flags.Arg(0), func(d *driver.Driver) error {
+		return d.Resize(*flavorUuid)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Machine %s now runs flavor %s\n", flags.Arg(0), *flavorUuid)
+	return nil
+}
@@ -0,0 +1,579 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest formats produced by the template command
+const (
+	// formatMachineConfig is the Rancher v2 provisioning machine config
+	formatMachineConfig = "machineconfig"
+	// formatNodeTemplate is the Rancher v3 node template
+	formatNodeTemplate = "nodetemplate"
+)
+
+// flagPrefix prefixes the names of the driver flags
+const flagPrefix = "waldur-"
+
+// credentialFlags are kept out of the manifests, Rancher provides them from a cloud credential
+var credentialFlags = []string{"waldur-api-token"}
+
+// stdin is read by the interactive mode, tests replace it
+var stdin io.Reader = os.Stdin
+
+// templateSpec is the YAML spec of a template. Options are driver flags, with or without
+// the waldur- prefix; the options taking UUIDs also accept names, resolved with the API.
+type templateSpec struct {
+	Name            string         `yaml:"name"`
+	Namespace       string         `yaml:"namespace"`
+	Format          string         `yaml:"format"`
+	CloudCredential string         `yaml:"cloudCredential"`
+	Options         map[string]any `yaml:"options"`
+}
+
+// flagKinds maps the driver flags taking UUIDs to the inventory kind of the objects
+var flagKinds = map[string]string{
+	"waldur-proj-uuid":             driver.InventoryProjects,
+	"waldur-offering-uuid":         driver.InventoryOfferings,
+	"waldur-flavor-uuid":           driver.InventoryFlavors,
+	"waldur-image-uuid":            driver.InventoryImages,
+	"waldur-sys-volume-type-uuid":  driver.InventoryVolumeTypes,
+	"waldur-data-volume-type-uuid": driver.InventoryVolumeTypes,
+	"waldur-subnet-uuids":          driver.InventorySubnets,
+	"waldur-sec-group-uuid":        driver.InventorySecurityGroups,
+	"waldur-sec-group-uuids":       driver.InventorySecurityGroups,
+}
+
+// resolveOrder lists the flags resolved before the others, the tenant objects need the offering
+var resolveOrder = []string{"waldur-proj-uuid", "waldur-offering-uuid"}
+
+// driverFlags returns the create flags of the driver by name
+func driverFlags() map[string]mcnflag.Flag {
+	flags := map[string]mcnflag.Flag{}
+	for _, flag := range driver.NewDriver("", "").GetCreateFlags() {
+		flags[flag.String()] = flag
+	}
+	return flags
+}
+
+// templateOptions holds typed driver options and serves them to SetConfigFromFlags,
+// falling back to the flag defaults
+type templateOptions struct {
+	flags  map[string]mcnflag.Flag
+	values map[string]any
+}
+
+func (o *templateOptions) value(key string) any {
+	if value, ok := o.values[key]; ok {
+		return value
+	}
+	if flag, ok := o.flags[key]; ok {
+		return flag.Default()
+	}
+	return nil
+}
+
+func (o *templateOptions) String(key string) string {
+	value, _ := o.value(key).(string)
+	return value
+}
+
+func (o *templateOptions) StringSlice(key string) []string {
+	value, _ := o.value(key).([]string)
+	return value
+}
+
+func (o *templateOptions) Int(key string) int {
+	value, _ := o.value(key).(int)
+	return value
+}
+
+func (o *templateOptions) Bool(key string) bool {
+	value, _ := o.value(key).(bool)
+	return value
+}
+
+// convertOption converts a spec value to the type of the flag
+func convertOption(flag mcnflag.Flag, value any) (any, error) {
+	switch flag.(type) {
+	case mcnflag.IntFlag:
+		switch typed := value.(type) {
+		case int:
+			return typed, nil
+		case string:
+			return strconv.Atoi(typed)
+		}
+	case mcnflag.BoolFlag:
+		switch typed := value.(type) {
+		case bool:
+			return typed, nil
+		case string:
+			return strconv.ParseBool(typed)
+		}
+	case mcnflag.StringSliceFlag:
+		switch typed := value.(type) {
+		case string:
+			return strings.Split(typed, ","), nil
+		case []any:
+			values := make([]string, len(typed))
+			for i, item := range typed {
+				values[i] = fmt.Sprint(item)
+			}
+			return values, nil
+		case []string:
+			return typed, nil
+		}
+	default:
+		switch value.(type) {
+		case string, int, bool, float64:
+			return fmt.Sprint(value), nil
+		case map[string]any, []any:
+			// Structured options such as ports are passed as JSON or YAML text
+			data, err := yaml.Marshal(value)
+			return strings.TrimSpace(string(data)), err
+		}
+	}
+	return nil, fmt.Errorf("unsupported value %v", value)
+}
+
+// parseTemplateOptions checks the spec options against the driver flags and converts them
+func parseTemplateOptions(spec map[string]any) (*templateOptions, error) {
+	options := &templateOptions{flags: driverFlags(), values: map[string]any{}}
+	for key, value := range spec {
+		name := key
+		if !strings.HasPrefix(name, flagPrefix) {
+			name = flagPrefix + name
+		}
+		flag, ok := options.flags[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+		if slices.Contains(credentialFlags, name) {
+			return nil, fmt.Errorf("option %q belongs in a cloud credential, not in a template kept in Git", key)
+		}
+		converted, err := convertOption(flag, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid option %q: %w", key, err)
+		}
+		options.values[name] = converted
+	}
+	return options, nil
+}
+
+// resolveItem returns the UUID of the inventory item with the UUID or name
+func resolveItem(kind string, items []driver.InventoryItem, ref string) (string, error) {
+	matches := []string{}
+	for _, item := range items {
+		if item.Uuid == ref {
+			return item.Uuid, nil
+		}
+		if item.Name == ref {
+			matches = append(matches, item.Uuid)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no %s named or with UUID %q", kind, ref)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%d %s are named %q, please use a UUID: %s", len(matches), kind, ref, strings.Join(matches, ", "))
+}
+
+// resolveOptions replaces the names in the options taking UUIDs and checks that the
+// referenced objects are reachable with the token
+func resolveOptions(d *driver.Driver, options *templateOptions) error {
+	names := slices.Clone(resolveOrder)
+	for name := range flagKinds {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names[len(resolveOrder):])
+
+	inventory := map[string][]driver.InventoryItem{}
+	for _, name := range names {
+		value, ok := options.values[name]
+		if !ok {
+			continue
+		}
+		kind := flagKinds[name]
+		if _, listed := inventory[kind]; !listed {
+			items, err := d.ListInventory(kind)
+			if err != nil {
+				return fmt.Errorf("unable to list %s: %w", kind, err)
+			}
+			inventory[kind] = items
+		}
+
+		switch typed := value.(type) {
+		case string:
+			resolved, err := resolveItem(kind, inventory[kind], typed)
+			if err != nil {
+				return fmt.Errorf("option %s: %w", name, err)
+			}
+			options.values[name] = resolved
+		case []string:
+			resolved := make([]string, len(typed))
+			for i, ref := range typed {
+				var err error
+				if resolved[i], err = resolveItem(kind, inventory[kind], ref); err != nil {
+					return fmt.Errorf("option %s: %w", name, err)
+				}
+			}
+			options.values[name] = resolved
+		}
+		if name == "waldur-offering-uuid" {
+			d.OfferingUuid = options.values[name].(string)
+		}
+	}
+	return nil
+}
+
+// yamlMapping builds an ordered YAML mapping from key and value pairs
+func yamlMapping(pairs ...any) (*yaml.Node, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("odd number of YAML mapping pairs: %v", pairs)
+	}
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < len(pairs); i += 2 {
+		name, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("YAML mapping key %v is not a string", pairs[i])
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: name}
+		value, ok := pairs[i+1].(*yaml.Node)
+		if !ok {
+			value = &yaml.Node{}
+			if err := value.Encode(pairs[i+1]); err != nil {
+				return nil, fmt.Errorf("unable to encode %s: %w", name, err)
+			}
+		}
+		node.Content = append(node.Content, key, value)
+	}
+	return node, nil
+}
+
+// configNode returns the driver config with the options set in the spec, sorted by field.
+// Rancher stores numbers as strings in driver configs.
+func configNode(options *templateOptions) (*yaml.Node, error) {
+	names := []string{}
+	for name := range options.values {
+		if !slices.Contains(credentialFlags, name) {
+			names = append(names, name)
+		}
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return strings.Compare(driver.ConfigFieldName(a), driver.ConfigFieldName(b))
+	})
+	pairs := []any{}
+	for _, name := range names {
+		value := options.values[name]
+		if number, ok := value.(int); ok {
+			value = strconv.Itoa(number)
+		}
+		pairs = append(pairs, driver.ConfigFieldName(name), value)
+	}
+	return yamlMapping(pairs...)
+}
+
+// renderManifest renders the Rancher manifest of the spec with the resolved options
+func renderManifest(spec *templateSpec, options *templateOptions) ([]byte, error) {
+	namespace := spec.Namespace
+	switch spec.Format {
+	case "", formatMachineConfig:
+		if namespace == "" {
+			namespace = "fleet-default"
+		}
+	case formatNodeTemplate:
+		if namespace == "" {
+			namespace = "cattle-global-nt"
+		}
+	default:
+		return nil, fmt.Errorf("invalid format %q, expected %s or %s", spec.Format, formatMachineConfig, formatNodeTemplate)
+	}
+	metadata, err := yamlMapping("name", spec.Name, "namespace", namespace)
+	if err != nil {
+		return nil, err
+	}
+	config, err := configNode(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var document *yaml.Node
+	if spec.Format == formatNodeTemplate {
+		templateSpec := []any{"driver", "waldur", "displayName", spec.Name}
+		if spec.CloudCredential != "" {
+			templateSpec = append(templateSpec, "cloudCredentialName", spec.CloudCredential)
+		}
+		specNode, err := yamlMapping(templateSpec...)
+		if err != nil {
+			return nil, err
+		}
+		document, err = yamlMapping(
+			"apiVersion", "management.cattle.io/v3",
+			"kind", "NodeTemplate",
+			"metadata", metadata,
+			"spec", specNode,
+			"waldurConfig", config,
+		)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		document, err = yamlMapping(
+			"apiVersion", "rke-machine-config.cattle.io/v1",
+			"kind", "WaldurConfig",
+			"metadata", metadata,
+		)
+		if err != nil {
+			return nil, err
+		}
+		// Machine configs keep the driver fields at the top level
+		document.Content = append(document.Content, config.Content...)
+	}
+	return yaml.Marshal(document)
+}
+
+// loadTemplateSpec reads a YAML template spec
+func loadTemplateSpec(path string) (*templateSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read template spec: %w", err)
+	}
+	spec := &templateSpec{}
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("invalid template spec %s: %w", path, err)
+	}
+	if spec.Options == nil {
+		spec.Options = map[string]any{}
+	}
+	return spec, nil
+}
+
+// prompter asks for the template values on the terminal
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints the question and returns the trimmed answer, the default when empty
+func (p *prompter) ask(question, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return "", fmt.Errorf("no answer to %q: %w", question, err)
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+// choose lists the items and returns the UUID of the chosen one, by number, name or UUID.
+// Optional choices can be skipped with an empty answer.
+func (p *prompter) choose(question string, items []driver.InventoryItem, optional bool) (string, error) {
+	if len(items) == 0 {
+		if optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("nothing to choose for %s", question)
+	}
+	for i, item := range items {
+		fmt.Fprintf(p.out, "  %d) %s %s %s\n", i+1, item.Name, item.Uuid, item.Details)
+	}
+	for {
+		defaultValue := "1"
+		if optional {
+			defaultValue = ""
+		}
+		answer, err := p.ask(question, defaultValue)
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			return "", nil
+		}
+		if number, err := strconv.Atoi(answer); err == nil && number >= 1 && number <= len(items) {
+			return items[number-1].Uuid, nil
+		}
+		if resolved, err := resolveItem(question, items, answer); err == nil {
+			return resolved, nil
+		}
+		fmt.Fprintf(p.out, "Please answer with a number from 1 to %d, a name or a UUID\n", len(items))
+	}
+}
+
+// interactiveSpec builds a template spec by asking for each value, listing the objects to choose from
+func interactiveSpec(d *driver.Driver, in io.Reader, out io.Writer) (*templateSpec, error) {
+	p := &prompter{in: bufio.NewReader(in), out: out}
+	spec := &templateSpec{Options: map[string]any{"api-url": d.ApiUrl}}
+	var err error
+	if spec.Name, err = p.ask("Template name", ""); err != nil {
+		return nil, err
+	}
+	if spec.Format, err = p.ask("Format (machineconfig or nodetemplate)", formatMachineConfig); err != nil {
+		return nil, err
+	}
+	if spec.CloudCredential, err = p.ask("Cloud credential holding the API token", ""); err != nil {
+		return nil, err
+	}
+
+	questions := []struct {
+		flag, question string
+		optional       bool
+	}{
+		{"waldur-proj-uuid", "Project", false},
+		{"waldur-offering-uuid", "Offering", false},
+		{"waldur-flavor-uuid", "Flavor", false},
+		{"waldur-image-uuid", "Image", false},
+		{"waldur-sys-volume-type-uuid", "System volume type", false},
+		{"waldur-data-volume-type-uuid", "Data volume type", false},
+		{"waldur-subnet-uuids", "Subnet (empty to select automatically)", true},
+		{"waldur-sec-group-uuid", "Security group (empty to create one per cluster)", true},
+	}
+	for _, q := range questions {
+		items, err := d.ListInventory(flagKinds[q.flag])
+		if err != nil {
+			return nil, fmt.Errorf("unable to list %s: %w", flagKinds[q.flag], err)
+		}
+		fmt.Fprintf(out, "%s:\n", q.question)
+		choice, err := p.choose(q.question, items, q.optional)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case choice != "":
+			spec.Options[q.flag] = choice
+		case q.flag == "waldur-sec-group-uuid":
+			spec.Options["waldur-sec-group-auto"] = true
+			// the created group only opens the node ports to this CIDR, which has no default
+			cidr := ""
+			for cidr == "" {
+				if cidr, err = p.ask("CIDR allowed to reach the node ports", ""); err != nil {
+					return nil, err
+				}
+			}
+			spec.Options["waldur-sec-group-cidr"] = cidr
+			// the created group is named after the cluster, which has no default either
+			cluster := ""
+			for cluster == "" {
+				if cluster, err = p.ask("Rancher cluster name", ""); err != nil {
+					return nil, err
+				}
+			}
+			spec.Options["waldur-cluster-name"] = cluster
+		}
+		if q.flag == "waldur-offering-uuid" {
+			d.OfferingUuid = choice
+		}
+	}
+
+	size, err := p.ask("System volume size in GB", "20")
+	if err != nil {
+		return nil, err
+	}
+	spec.Options["waldur-sys-volume-size"] = size
+	return spec, nil
+}
+
+// runTemplate produces a validated Rancher machine config or node template manifest
+func runTemplate(args []string, stdout io.Writer) error {
+	flags := newFlagSet("template", stdout)
+	apiUrl, apiToken := addConnectionFlags(flags)
+	interactive := flags.Bool("interactive", false, "Ask for the values instead of reading a spec")
+	output := flags.String("output", "", "File to write the manifest to instead of the standard output")
+	flags.Usage = func() {
+		fmt.Fprintln(stdout, "Usage: template [options] SPEC | template -interactive [options]")
+		fmt.Fprintln(stdout, "Produce a Rancher machine config or node template from a YAML spec, resolving and checking its values with the Waldur API.")
+		fmt.Fprintln(stdout, "The spec has a name, namespace, format (machineconfig or nodetemplate), cloudCredential and the driver options.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *interactive == (flags.NArg() == 1) || flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("template requires either a spec or -interactive")
+	}
+	if *apiToken == "" {
+		return fmt.Errorf("template requires the -api-token option to check the values")
+	}
+
+	d := driver.NewDriver("template", defaultStoragePath())
+	d.ApiToken = *apiToken
+	var spec *templateSpec
+	var err error
+	if *interactive {
+		if *apiUrl == "" {
+			return fmt.Errorf("template requires the -api-url option")
+		}
+		d.ApiUrl = *apiUrl
+		if spec, err = interactiveSpec(d, stdin, stdout); err != nil {
+			return err
+		}
+	} else if spec, err = loadTemplateSpec(flags.Arg(0)); err != nil {
+		return err
+	}
+	if spec.Name == "" {
+		return fmt.Errorf("the template requires a name")
+	}
+	if spec.Format == "" {
+		spec.Format = formatMachineConfig
+	}
+
+	options, err := parseTemplateOptions(spec.Options)
+	if err != nil {
+		return err
+	}
+	if options.String("waldur-api-url") == "" {
+		options.values["waldur-api-url"] = *apiUrl
+	}
+	d.ApiUrl = options.String("waldur-api-url")
+	if d.ApiUrl == "" {
+		return fmt.Errorf("the template requires the api-url option or -api-url")
+	}
+	if err := resolveOptions(d, options); err != nil {
+		return err
+	}
+
+	// The driver validates the options as rancher-machine would, with the token of the cloud
+	// credential in place of a token file or variable which only exists where the driver runs
+	validation := &templateOptions{flags: options.flags, values: maps.Clone(options.values)}
+	delete(validation.values, "waldur-api-token-file")
+	delete(validation.values, "waldur-api-token-env")
+	validation.values["waldur-api-token"] = *apiToken
+	if err := driver.NewDriver(spec.Name, defaultStoragePath()).SetConfigFromFlags(validation); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+
+	manifest, err := renderManifest(spec, options)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		if err := os.WriteFile(*output, manifest, 0o644); err != nil {
+			return fmt.Errorf("unable to write the manifest: %w", err)
+		}
+		fmt.Fprintf(stdout, "Wrote %s manifest %s to %s\n", spec.Format, spec.Name, *output)
+		return nil
+	}
+	_, err = stdout.Write(manifest)
+	return err
+}
@@ -0,0 +1,234 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+)
+
+func TestParseTemplateOptions(t *testing.T) {
+	options, err := parseTemplateOptions(map[string]any{
+		"proj-uuid":              "k8s",
+		"waldur-sys-volume-size": "40",
+		"sec-group-auto":         true,
+		"subnet-uuids":           []any{"internal", "storage"},
+		"sec-group-uuids":        "web,ssh",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.String("waldur-proj-uuid") != "k8s" {
+		t.Errorf("unexpected project %q", options.String("waldur-proj-uuid"))
+	}
+	if options.Int("waldur-sys-volume-size") != 40 {
+		t.Errorf("unexpected volume size %d", options.Int("waldur-sys-volume-size"))
+	}
+	if !options.Bool("waldur-sec-group-auto") {
+		t.Error("expected the security group to be created")
+	}
+	if !slices.Equal(options.StringSlice("waldur-subnet-uuids"), []string{"internal", "storage"}) {
+		t.Errorf("unexpected subnets %v", options.StringSlice("waldur-subnet-uuids"))
+	}
+	if !slices.Equal(options.StringSlice("waldur-sec-group-uuids"), []string{"web", "ssh"}) {
+		t.Errorf("unexpected security groups %v", options.StringSlice("waldur-sec-group-uuids"))
+	}
+	// Unset options fall back to the flag defaults
+	if options.String("waldur-source") != "image" {
+		t.Errorf("unexpected default source %q", options.String("waldur-source"))
+	}
+}
+
+func TestParseTemplateOptionsRejectsInvalidOptions(t *testing.T) {
+	for option, expected := range map[string]string{
+		"flavor":          `unknown option "flavor"`,
+		"api-token":       "cloud credential",
+		"sys-volume-size": `invalid option "sys-volume-size"`,
+	} {
+		_, err := parseTemplateOptions(map[string]any{option: "large"})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("option %s: expected an error containing %q, got %v", option, expected, err)
+		}
+	}
+}
+
+func TestResolveItem(t *testing.T) {
+	items := []driver.InventoryItem{
+		{Uuid: "f1", Name: "m1.small"},
+		{Uuid: "f2", Name: "m1.large"},
+		{Uuid: "f3", Name: "m1.large"},
+	}
+	if resolved, err := resolveItem("flavors", items, "m1.small"); err != nil || resolved != "f1" {
+		t.Errorf("expected f1, got %q, %v", resolved, err)
+	}
+	if resolved, err := resolveItem("flavors", items, "f3"); err != nil || resolved != "f3" {
+		t.Errorf("expected f3, got %q, %v", resolved, err)
+	}
+	if _, err := resolveItem("flavors", items, "m1.large"); err == nil || !strings.Contains(err.Error(), "please use a UUID") {
+		t.Errorf("expected an ambiguity error, got %v", err)
+	}
+	if _, err := resolveItem("flavors", items, "m1.tiny"); err == nil {
+		t.Error("expected an error for an unknown flavor")
+	}
+}
+
+func TestRenderManifest(t *testing.T) {
+	options, err := parseTemplateOptions(map[string]any{
+		"api-url":         "https://waldur.example.com/",
+		"proj-uuid":       "p1",
+		"sys-volume-size": 40,
+		"subnet-uuids":    []any{"s1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machineConfig, err := renderManifest(&templateSpec{Name: "workers"}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `apiVersion: rke-machine-config.cattle.io/v1
+kind: WaldurConfig
+metadata:
+    name: workers
+    namespace: fleet-default
+apiUrl: https://waldur.example.com/
+projUuid: p1
+subnetUuids:
+    - s1
+sysVolumeSize: "40"
+`
+	if string(machineConfig) != expected {
+		t.Errorf("unexpected machine config:\n%s", machineConfig)
+	}
+
+	nodeTemplate, err := renderManifest(&templateSpec{Name: "workers", Format: formatNodeTemplate, CloudCredential: "cattle-global-data:cc-waldur"}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fragment := range []string{"kind: NodeTemplate\n", "    driver: waldur\n", "    cloudCredentialName: cattle-global-data:cc-waldur\n", "waldurConfig:\n    apiUrl: https://waldur.example.com/\n"} {
+		if !strings.Contains(string(nodeTemplate), fragment) {
+			t.Errorf("node template lacks %q:\n%s", fragment, nodeTemplate)
+		}
+	}
+
+	if _, err := renderManifest(&templateSpec{Name: "workers", Format: "cluster"}, options); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestLoadTemplateSpec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workers.yaml")
+	spec := `name: workers
+format: nodetemplate
+cloudCredential: cc-waldur
+options:
+  proj-uuid: k8s
+  waldur-sys-volume-size: 40
+  ports:
+    - port: 22
+`
+	if err := os.WriteFile(path, []byte(spec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadTemplateSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Name != "workers" || loaded.Format != formatNodeTemplate || loaded.CloudCredential != "cc-waldur" {
+		t.Errorf("unexpected spec %+v", loaded)
+	}
+	options, err := parseTemplateOptions(loaded.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Int("waldur-sys-volume-size") != 40 {
+		t.Errorf("unexpected volume size %d", options.Int("waldur-sys-volume-size"))
+	}
+	if options.String("waldur-ports") != "- port: 22" {
+		t.Errorf("unexpected ports %q", options.String("waldur-ports"))
+	}
+}
+
+func TestPrompterChoose(t *testing.T) {
+	var out bytes.Buffer
+	items := []driver.InventoryItem{{Uuid: "f1", Name: "m1.small"}, {Uuid: "f2", Name: "m1.large"}}
+	p := &prompter{in: bufio.NewReader(strings.NewReader("7\nm1.large\n\n")), out: &out}
+
+	choice, err := p.choose("Flavor", items, false)
+	if err != nil || choice != "f2" {
+		t.Errorf("expected f2 after an invalid answer, got %q, %v", choice, err)
+	}
+	if !strings.Contains(out.String(), "Please answer with a number from 1 to 2") {
+		t.Errorf("expected a retry prompt:\n%s", out.String())
+	}
+	choice, err = p.choose("Security group", items, true)
+	if err != nil || choice != "" {
+		t.Errorf("expected an optional choice to be skipped, got %q, %v", choice, err)
+	}
+}
+
+func TestTemplateRequiresSpecOrInteractive(t *testing.T) {
+	var stdout bytes.Buffer
+	err := runTemplate([]string{"-api-token", "token"}, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "either a spec or -interactive") {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+}
+
+func TestInteractiveTemplateAsksForSecurityGroupCidr(t *testing.T) {
+	// every listing has one object named after its kind, the offering is connected to a tenant
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(r.URL.Path, "/api/marketplace-public-offerings/") && r.URL.Path != "/api/marketplace-public-offerings/" {
+			json.NewEncoder(w).Encode(map[string]string{"uuid": "00000000-0000-0000-0000-000000000002", "scope_uuid": "00000000-0000-0000-0000-000000000099"})
+			return
+		}
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+		json.NewEncoder(w).Encode([]map[string]string{{"uuid": "00000000-0000-0000-0000-000000000002", "name": name}})
+	}))
+	defer server.Close()
+
+	// name, format, credential, project, offering, flavor, image, volume types, no subnet,
+	// no security group, an empty then a given CIDR, the cluster and the default system volume size
+	stdin = strings.NewReader("workers\n\ncattle-global-data:cc-waldur\n1\n1\n1\n1\n1\n1\n\n\n\n10.0.0.0/8\nprod\n\n")
+	defer func() { stdin = os.Stdin }()
+	var stdout bytes.Buffer
+	if err := runTemplate([]string{"-interactive", "-api-url", server.URL + "/", "-api-token", "token"}, &stdout); err != nil {
+		t.Fatalf("%v\n%s", err, stdout.String())
+	}
+	output := stdout.String()
+	for _, expected := range []string{"secGroupAuto: true", "secGroupCidr: 10.0.0.0/8", "clusterName: prod", "CIDR allowed to reach the node ports"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in the output:\n%s", expected, output)
+		}
+	}
+}
+
+// failingMarshaler is a value whose YAML encoding fails
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalYAML() (any, error) {
+	return nil, errors.New("not encodable")
+}
+
+func TestYAMLMappingErrors(t *testing.T) {
+	if _, err := yamlMapping("name"); err == nil {
+		t.Error("expected an error for a key without a value")
+	}
+	if _, err := yamlMapping(1, "value"); err == nil {
+		t.Error("expected an error for a key which is not a string")
+	}
+	if _, err := yamlMapping("value", failingMarshaler{}); err == nil || !strings.Contains(err.Error(), "not encodable") {
+		t.Error("expected an error for a value YAML cannot encode")
+	}
+}
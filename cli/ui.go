@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+	"gopkg.in/yaml.v3"
+)
+
+// tokenHeader carries the Waldur API token of the Rancher user to the helper
+const tokenHeader = "X-Waldur-Token"
+
+// nodeDriverManifest renders the Rancher NodeDriver resource registering the driver with its UI annotations
+func nodeDriverManifest(url, checksum, uiUrl string) ([]byte, error) {
+	values := driver.NewDriver("", "").NodeDriverAnnotations()
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	pairs := []any{}
+	for _, key := range keys {
+		pairs = append(pairs, key, values[key])
+	}
+	annotations, err := yamlMapping(pairs...)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := yamlMapping("name", "waldur", "annotations", annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := []any{"active", true, "builtin", false, "displayName", "waldur", "url", url}
+	if checksum != "" {
+		spec = append(spec, "checksum", checksum)
+	}
+	if uiUrl != "" {
+		spec = append(spec, "uiUrl", uiUrl)
+	}
+	specNode, err := yamlMapping(spec...)
+	if err != nil {
+		return nil, err
+	}
+	document, err := yamlMapping(
+		"apiVersion", "management.cattle.io/v3",
+		"kind", "NodeDriver",
+		"metadata", metadata,
+		"spec", specNode,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(document)
+}
+
+// runUI prints the UI metadata of the driver fields, or the NodeDriver resource carrying its annotations
+func runUI(args []string, stdout io.Writer) error {
+	flags := newFlagSet("ui", stdout)
+	format := flags.String("format", "json", "Output format: json for the field metadata, or nodedriver for the Rancher NodeDriver resource")
+	url := flags.String("url", "", "Download URL of the driver binary, for the nodedriver format")
+	checksum := flags.String("checksum", "", "SHA256 checksum of the driver binary, for the nodedriver format")
+	uiUrl := flags.String("ui-url", "", "URL of the custom UI component, for the nodedriver format")
+	flags.Usage = func() {
+		fmt.Fprintln(stdout, "Usage: ui [options]")
+		fmt.Fprintln(stdout, "Print the Rancher UI metadata of the driver fields: types, groups, defaults and secrets.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("ui accepts no arguments")
+	}
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(driver.NewDriver("", "").UIFields())
+	case "nodedriver":
+		if *url == "" {
+			return fmt.Errorf("the nodedriver format requires the -url option")
+		}
+		manifest, err := nodeDriverManifest(*url, *checksum, *uiUrl)
+		if err != nil {
+			return err
+		}
+		_, err = stdout.Write(manifest)
+		return err
+	}
+	return fmt.Errorf("invalid format %q, expected json or nodedriver", *format)
+}
+
+// optionsHandler serves the UI metadata and the dynamic option lists of the driver fields
+type optionsHandler struct {
+	apiUrl string
+	origin string
+	mux    *http.ServeMux
+}
+
+// newOptionsHandler returns the helper handler. Requests list options with their own token
+// from the X-Waldur-Token header, the helper holds no token of its own.
+func newOptionsHandler(apiUrl, origin string) http.Handler {
+	h := &optionsHandler{apiUrl: apiUrl, origin: origin, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	h.mux.HandleFunc("GET /v1/fields", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, driver.NewDriver("", "").UIFields())
+	})
+	h.mux.HandleFunc("GET /v1/options/{kind}", h.serveOptions)
+	return h
+}
+
+func (h *optionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", h.origin)
+		w.Header().Set("Access-Control-Allow-Headers", tokenHeader)
+		w.Header().Set("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// serveOptions lists the objects of an inventory kind, the tenant kinds require the offeringUuid parameter
+func (h *optionsHandler) serveOptions(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	if !slices.Contains(driver.InventoryKinds, kind) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown kind %q, expected one of %s", kind, strings.Join(driver.InventoryKinds, ", "))})
+		return
+	}
+	token := r.Header.Get(tokenHeader)
+	if token == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "the " + tokenHeader + " header is required"})
+		return
+	}
+
+	d := driver.NewDriver("ui", "")
+	d.ApiUrl = h.apiUrl
+	d.ApiToken = token
+	d.OfferingUuid = r.URL.Query().Get("offeringUuid")
+	items, err := d.ListInventory(kind)
+	if err != nil {
+		status := http.StatusBadGateway
+		var apiErr *driver.APIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+			status = apiErr.StatusCode
+		} else if d.OfferingUuid == "" && kind != driver.InventoryProjects && kind != driver.InventoryOfferings {
+			status = http.StatusBadRequest
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// writeJSON writes the value as a JSON response
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// runServe runs the HTTP helper serving dynamic option lists to a Rancher custom UI component
+func runServe(args []string, stdout io.Writer) error {
+	flags := newFlagSet("serve", stdout)
+	apiUrl := flags.String("api-url", os.Getenv("WALDUR_API_URL"), "Waldur API URL (WALDUR_API_URL)")
+	listen := flags.String("listen", "127.0.0.1:8080", "Address to listen on")
+	origin := flags.String("allowed-origin", "", "Origin of the Rancher UI allowed to call the helper from a browser")
+	flags.Usage = func() {
+		fmt.Fprintln(stdout, "Usage: serve [options]")
+		fmt.Fprintln(stdout, "Serve the field metadata on /v1/fields and the options of a kind on /v1/options/KIND?offeringUuid=UUID.")
+		fmt.Fprintf(stdout, "Requests must carry their Waldur API token in the %s header.\n", tokenHeader)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("serve accepts no arguments")
+	}
+	if *apiUrl == "" {
+		return fmt.Errorf("serve requires the -api-url option")
+	}
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           newOptionsHandler(*apiUrl, *origin),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Fprintf(stdout, "Serving the driver options of %s on %s\n", *apiUrl, *listen)
+	return server.ListenAndServe()
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+)
+
+func TestUIPrintsFields(t *testing.T) {
+	var stdout bytes.Buffer
+	if err := runUI(nil, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	fields := []driver.UIField{}
+	if err := json.Unmarshal(stdout.Bytes(), &fields); err != nil {
+		t.Fatalf("invalid output: %v\n%s", err, stdout.String())
+	}
+	if len(fields) == 0 || fields[0].Group != "Connection" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
+
+func TestNodeDriverManifest(t *testing.T) {
+	manifest, err := nodeDriverManifest("https://example.com/waldur", "abc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fragment := range []string{
+		"kind: NodeDriver\n",
+		"        privateCredentialFields: apiToken\n",
+		"        publicCredentialFields: apiUrl,apiTokenFile,apiTokenEnv\n",
+		"    url: https://example.com/waldur\n",
+		"    checksum: abc\n",
+	} {
+		if !strings.Contains(string(manifest), fragment) {
+			t.Errorf("manifest lacks %q:\n%s", fragment, manifest)
+		}
+	}
+	if strings.Contains(string(manifest), "uiUrl") {
+		t.Errorf("manifest should omit the empty UI URL:\n%s", manifest)
+	}
+}
+
+func TestOptionsHandler(t *testing.T) {
+	handler := newOptionsHandler("https://waldur.example.com/", "https://rancher.example.com")
+
+	fields := httptest.NewRecorder()
+	handler.ServeHTTP(fields, httptest.NewRequest(http.MethodGet, "/v1/fields", nil))
+	if fields.Code != http.StatusOK || !strings.Contains(fields.Body.String(), `"field":"apiToken"`) {
+		t.Errorf("unexpected fields response %d: %s", fields.Code, fields.Body.String())
+	}
+	if origin := fields.Header().Get("Access-Control-Allow-Origin"); origin != "https://rancher.example.com" {
+		t.Errorf("unexpected allowed origin %q", origin)
+	}
+
+	unknown := httptest.NewRecorder()
+	handler.ServeHTTP(unknown, httptest.NewRequest(http.MethodGet, "/v1/options/keypairs", nil))
+	if unknown.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown kind, got %d", unknown.Code)
+	}
+
+	anonymous := httptest.NewRecorder()
+	handler.ServeHTTP(anonymous, httptest.NewRequest(http.MethodGet, "/v1/options/flavors", nil))
+	if anonymous.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", anonymous.Code)
+	}
+
+	withoutOffering := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/v1/options/flavors", nil)
+	request.Header.Set(tokenHeader, "token")
+	handler.ServeHTTP(withoutOffering, request)
+	if withoutOffering.Code != http.StatusBadRequest || !strings.Contains(withoutOffering.Body.String(), "offering UUID") {
+		t.Errorf("expected 400 without an offering, got %d: %s", withoutOffering.Code, withoutOffering.Body.String())
+	}
+
+	preflight := httptest.NewRecorder()
+	handler.ServeHTTP(preflight, httptest.NewRequest(http.MethodOptions, "/v1/options/flavors", nil))
+	if preflight.Code != http.StatusNoContent {
+		t.Errorf("expected 204 for a preflight request, got %d", preflight.Code)
+	}
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/waldur/waldur-rancher-node-driver/driver"
+)
+
+// runExtendVolume grows a volume of a machine and persists the new size in the machine config
+func runExtendVolume(args []string, stdout io.Writer) error {
+	flags := newFlagSet("extend-volume", stdout)
+	storagePath := addStoragePathFlag(flags)
+	volume := flags.String("volume", driver.VolumeSystem, "Volume to grow, system or data")
+	size := flags.Int("size", 0, "New size of the volume in GB")
+	flags.Usage = func() {
+		fmt.Fprintln(stdout, "Usage: extend-volume [options] MACHINE")
+		fmt.Fprintln(stdout, "Grow a volume of a machine, stopping it for the extension if it is running,")
+		fmt.Fprintln(stdout, "then grow the partition and filesystem over SSH.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("extend-volume requires exactly one machine name")
+	}
+	if *size <= 0 {
+		return fmt.Errorf("extend-volume requires the -size option")
+	}
+
+	err := updateMachine(*storagePath, flags.Arg(0), func(d *driver.Driver) error {
+		return d.ExtendVolume(*volume, *size)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Machine %s now has a %d GB %s volume\n", flags.Arg(0), *size, *volume)
+	return nil
+}
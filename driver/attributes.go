@@ -0,0 +1,82 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+	"gopkg.in/yaml.v3"
+)
+
+// parseOrderAttributes parses a JSON or YAML mapping of extra order attributes
+func parseOrderAttributes(value string) (map[string]any, error) {
+	attributes := map[string]any{}
+	if strings.TrimSpace(value) == "" {
+		return attributes, nil
+	}
+	if err := yaml.Unmarshal([]byte(value), &attributes); err != nil {
+		return nil, fmt.Errorf("invalid order attributes, expected a JSON or YAML mapping: %w", err)
+	}
+	return attributes, nil
+}
+
+// knownOrderAttributes returns the attribute names modelled by the generated client
+func knownOrderAttributes() map[string]bool {
+	known := map[string]bool{}
+	attributesType := reflect.TypeOf(waldurclient.OpenStackInstanceCreateOrderAttributes{})
+	for i := 0; i < attributesType.NumField(); i++ {
+		name, _, _ := strings.Cut(attributesType.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+	return known
+}
+
+// buildOrderAttributes merges the extra order attributes over the ones built by the driver.
+// Known attributes are validated against the generated model, unknown ones are passed through.
+func (d *Driver) buildOrderAttributes(base waldurclient.OpenStackInstanceCreateOrderAttributes) (waldurclient.OrderCreateRequest_Attributes, error) {
+	attributes := waldurclient.OrderCreateRequest_Attributes{}
+	if len(d.OrderAttributes) == 0 {
+		err := attributes.FromOpenStackInstanceCreateOrderAttributes(base)
+		return attributes, err
+	}
+
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return attributes, err
+	}
+	merged := map[string]any{}
+	if err := json.Unmarshal(baseJSON, &merged); err != nil {
+		return attributes, err
+	}
+
+	known := knownOrderAttributes()
+	unknown := []string{}
+	for key, value := range d.OrderAttributes {
+		merged[key] = value
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	mergedJSON, err := json.Marshal(merged)
+	if err != nil {
+		return attributes, fmt.Errorf("invalid order attributes: %w", err)
+	}
+	var validated waldurclient.OpenStackInstanceCreateOrderAttributes
+	if err := json.Unmarshal(mergedJSON, &validated); err != nil {
+		return attributes, fmt.Errorf("invalid order attributes: %w", err)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		log.Infof("Passing through order attributes unknown to the driver: %s", strings.Join(unknown, ", "))
+	}
+
+	err = attributes.UnmarshalJSON(mergedJSON)
+	return attributes, err
+}
@@ -0,0 +1,63 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOrderAttributes(t *testing.T) {
+	for _, value := range []string{
+		`{"availability_zone": "nova", "description": "worker"}`,
+		"availability_zone: nova\ndescription: worker\n",
+	} {
+		attributes, err := parseOrderAttributes(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attributes["availability_zone"] != "nova" || attributes["description"] != "worker" {
+			t.Errorf("unexpected attributes %v", attributes)
+		}
+	}
+
+	if _, err := parseOrderAttributes("- not\n- a mapping\n"); err == nil {
+		t.Error("expected a list to be rejected")
+	}
+}
+
+func TestCreateWithOrderAttributes(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	var err error
+	d.OrderAttributes, err = parseOrderAttributes("description: worker\nconnect_directly_to_external_network: true\nfuture_field: [1, 2]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	attributes := f.Orders[0]["attributes"].(map[string]any)
+	if attributes["description"] != "worker" || attributes["connect_directly_to_external_network"] != true {
+		t.Errorf("known attributes were not merged: %v", attributes)
+	}
+	if _, ok := attributes["future_field"]; !ok {
+		t.Errorf("unknown attribute was not passed through: %v", attributes)
+	}
+	if attributes["name"] != "node-1" || attributes["flavor"] == nil {
+		t.Errorf("driver attributes were lost: %v", attributes)
+	}
+}
+
+func TestCreateRejectsMistypedOrderAttributes(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.OrderAttributes = map[string]any{"system_volume_size": "large"}
+
+	err := d.Create()
+	if err == nil || !strings.Contains(err.Error(), "invalid order attributes") {
+		t.Fatalf("expected invalid attributes error, got %v", err)
+	}
+	if len(f.Orders) != 0 {
+		t.Error("order was submitted despite invalid attributes")
+	}
+}
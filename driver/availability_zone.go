@@ -0,0 +1,87 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// listAvailabilityZones returns the available instance availability zones of the offering's tenant sorted by name
+func (d *Driver) listAvailabilityZones(client WaldurAPI) ([]waldurclient.OpenStackInstanceAvailabilityZone, error) {
+	tenantUuid, err := d.getTenantUuid(client)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	all, err := listAll(d, "availability zone listing", func(page, pageSize int) (int, []byte, *[]waldurclient.OpenStackInstanceAvailabilityZone, error) {
+		resp, err := client.OpenstackInstanceAvailabilityZonesListWithResponse(ctx, &waldurclient.OpenstackInstanceAvailabilityZonesListParams{
+			TenantUuid: &tenantUuid,
+			Page:       &page,
+			PageSize:   &pageSize,
+		})
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return resp.StatusCode(), resp.Body, resp.JSON200, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	zones := []waldurclient.OpenStackInstanceAvailabilityZone{}
+	for _, zone := range all {
+		if zone.Name == nil || zone.Url == nil || (zone.Available != nil && !*zone.Available) {
+			continue
+		}
+		zones = append(zones, zone)
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		return *zones[i].Name < *zones[j].Name
+	})
+	return zones, nil
+}
+
+// spreadIndex deterministically maps the machine name to one of n zones
+func spreadIndex(machineName string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(machineName))
+	return int(h.Sum32() % uint32(n))
+}
+
+// resolveAvailabilityZone returns the URL of the configured availability zone,
+// or of the zone assigned to the machine in spread mode, nil if neither is configured
+func (d *Driver) resolveAvailabilityZone(client WaldurAPI) (*string, error) {
+	if d.AvailabilityZone == "" && !d.AvailabilityZoneSpread {
+		return nil, nil
+	}
+
+	zones, err := d.listAvailabilityZones(client)
+	if err != nil {
+		return nil, err
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("tenant of offering %s has no available instance availability zones", d.OfferingUuid)
+	}
+
+	if d.AvailabilityZoneSpread {
+		zone := zones[spreadIndex(d.GetMachineName(), len(zones))]
+		log.Infof("Spreading instance %s to availability zone %s", d.GetMachineName(), *zone.Name)
+		return zone.Url, nil
+	}
+
+	names := make([]string, len(zones))
+	for i, zone := range zones {
+		if *zone.Name == d.AvailabilityZone || (zone.Uuid != nil && zone.Uuid.String() == d.AvailabilityZone) {
+			log.Infof("Using availability zone %s for instance %s", *zone.Name, d.GetMachineName())
+			return zone.Url, nil
+		}
+		names[i] = *zone.Name
+	}
+	return nil, fmt.Errorf("availability zone %s not found, available zones: %s", d.AvailabilityZone, strings.Join(names, ", "))
+}
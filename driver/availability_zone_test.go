@@ -0,0 +1,104 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// setTenantZones serves an offering backed by a tenant with the given availability zones
+func setTenantZones(f *fakeWaldur, d *Driver, names ...string) map[string]string {
+	tenantUuid := uuid.NewString()
+	f.SetObject("marketplace-public-offerings", d.OfferingUuid, map[string]any{"scope_uuid": tenantUuid})
+	urls := map[string]string{}
+	for _, name := range names {
+		zoneUuid := uuid.NewString()
+		urls[name] = d.ApiUrl + "/api/openstack-instance-availability-zones/" + zoneUuid + "/"
+		f.AddListItem("openstack-instance-availability-zones", map[string]any{
+			"uuid":        zoneUuid,
+			"url":         urls[name],
+			"name":        name,
+			"available":   true,
+			"tenant_uuid": tenantUuid,
+		})
+	}
+	f.AddListItem("openstack-instance-availability-zones", map[string]any{
+		"uuid": uuid.NewString(), "url": "http://other", "name": "other-tenant", "tenant_uuid": uuid.NewString(),
+	})
+	return urls
+}
+
+func TestCreateWithAvailabilityZone(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	urls := setTenantZones(f, d, "az-1", "az-2")
+	d.AvailabilityZone = "az-2"
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	attributes := f.Orders[0]["attributes"].(map[string]any)
+	if attributes["availability_zone"] != urls["az-2"] {
+		t.Errorf("unexpected availability zone %v", attributes["availability_zone"])
+	}
+}
+
+func TestAvailabilityZonesArePaged(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	shortPages(t)
+	urls := setTenantZones(f, d, "az-1", "az-2", "az-3")
+	d.AvailabilityZone = "az-3"
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	attributes := f.Orders[0]["attributes"].(map[string]any)
+	if attributes["availability_zone"] != urls["az-3"] {
+		t.Errorf("unexpected availability zone %v", attributes["availability_zone"])
+	}
+}
+
+func TestUnknownAvailabilityZone(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	setTenantZones(f, d, "az-1", "az-2")
+	d.AvailabilityZone = "other-tenant"
+
+	err := d.Create()
+	if err == nil || !strings.Contains(err.Error(), "available zones: az-1, az-2") {
+		t.Fatalf("expected unknown zone error, got %v", err)
+	}
+}
+
+func TestAvailabilityZoneSpread(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	setTenantZones(f, d, "az-3", "az-1", "az-2")
+	d.AvailabilityZoneSpread = true
+
+	client, err := d.getWaldurClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		d.MachineName = "pool1-" + uuid.NewString()[:5]
+		first, err := d.resolveAvailabilityZone(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := d.resolveAvailabilityZone(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *first != *second {
+			t.Fatalf("zone assignment of %s is not deterministic", d.MachineName)
+		}
+		used[*first] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("expected machines to be spread over several zones, got %v", used)
+	}
+}
@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// WaldurAPI is the subset of the generated Waldur client used by the driver.
+// *waldurclient.ClientWithResponses is the default implementation, mocks and
+// wrappers adding caching, retries or metrics can be injected via Driver.Client.
+type WaldurAPI interface {
+	MarketplaceOrdersCreateWithResponse(ctx context.Context, body waldurclient.MarketplaceOrdersCreateJSONRequestBody, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.MarketplaceOrdersCreateResponse, error)
+	MarketplaceResourcesRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.MarketplaceResourcesRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.MarketplaceResourcesRetrieveResponse, error)
+	MarketplaceResourcesListWithResponse(ctx context.Context, params *waldurclient.MarketplaceResourcesListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.MarketplaceResourcesListResponse, error)
+	MarketplaceResourcesPartialUpdateWithResponse(ctx context.Context, uuid uuid.UUID, body waldurclient.MarketplaceResourcesPartialUpdateJSONRequestBody, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.MarketplaceResourcesPartialUpdateResponse, error)
+	MarketplaceResourcesTerminateWithResponse(ctx context.Context, uuid uuid.UUID, body waldurclient.MarketplaceResourcesTerminateJSONRequestBody, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.MarketplaceResourcesTerminateResponse, error)
+	MarketplacePublicOfferingsRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.MarketplacePublicOfferingsRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.MarketplacePublicOfferingsRetrieveResponse, error)
+	ProjectsRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.ProjectsRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.ProjectsRetrieveResponse, error)
+	ProjectsListWithResponse(ctx context.Context, params *waldurclient.ProjectsListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.ProjectsListResponse, error)
+	MarketplacePublicOfferingsListWithResponse(ctx context.Context, params *waldurclient.MarketplacePublicOfferingsListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.MarketplacePublicOfferingsListResponse, error)
+	OpenstackFlavorsListWithResponse(ctx context.Context, params *waldurclient.OpenstackFlavorsListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackFlavorsListResponse, error)
+	OpenstackImagesListWithResponse(ctx context.Context, params *waldurclient.OpenstackImagesListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackImagesListResponse, error)
+	OpenstackVolumeTypesListWithResponse(ctx context.Context, params *waldurclient.OpenstackVolumeTypesListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackVolumeTypesListResponse, error)
+	OpenstackTenantsRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.OpenstackTenantsRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackTenantsRetrieveResponse, error)
+	OpenstackFlavorsRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.OpenstackFlavorsRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackFlavorsRetrieveResponse, error)
+	OpenstackTenantsCreateServerGroupWithResponse(ctx context.Context, uuid uuid.UUID, body waldurclient.OpenstackTenantsCreateServerGroupJSONRequestBody, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackTenantsCreateServerGroupResponse, error)
+	OpenstackServerGroupsListWithResponse(ctx context.Context, params *waldurclient.OpenstackServerGroupsListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackServerGroupsListResponse, error)
+	OpenstackServerGroupsRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.OpenstackServerGroupsRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackServerGroupsRetrieveResponse, error)
+	OpenstackServerGroupsDestroyWithResponse(ctx context.Context, uuid uuid.UUID, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackServerGroupsDestroyResponse, error)
+	OpenstackTenantsCreateSecurityGroupWithBodyWithResponse(ctx context.Context, uuid uuid.UUID, contentType string, body io.Reader, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackTenantsCreateSecurityGroupResponse, error)
+	OpenstackSecurityGroupsListWithResponse(ctx context.Context, params *waldurclient.OpenstackSecurityGroupsListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackSecurityGroupsListResponse, error)
+	OpenstackSecurityGroupsRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.OpenstackSecurityGroupsRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackSecurityGroupsRetrieveResponse, error)
+	OpenstackSecurityGroupsDestroyWithResponse(ctx context.Context, uuid uuid.UUID, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackSecurityGroupsDestroyResponse, error)
+	OpenstackNetworksListWithResponse(ctx context.Context, params *waldurclient.OpenstackNetworksListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackNetworksListResponse, error)
+	OpenstackVolumesRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.OpenstackVolumesRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackVolumesRetrieveResponse, error)
+	OpenstackVolumesExtendWithResponse(ctx context.Context, uuid uuid.UUID, body waldurclient.OpenstackVolumesExtendJSONRequestBody, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackVolumesExtendResponse, error)
+	OpenstackVolumesSnapshotWithResponse(ctx context.Context, uuid uuid.UUID, body waldurclient.OpenstackVolumesSnapshotJSONRequestBody, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackVolumesSnapshotResponse, error)
+	OpenstackSnapshotsRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.OpenstackSnapshotsRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackSnapshotsRetrieveResponse, error)
+	OpenstackInstancesBackupWithResponse(ctx context.Context, uuid uuid.UUID, body waldurclient.OpenstackInstancesBackupJSONRequestBody, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesBackupResponse, error)
+	OpenstackBackupsRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.OpenstackBackupsRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackBackupsRetrieveResponse, error)
+	OpenstackBackupsRestoreWithResponse(ctx context.Context, uuid uuid.UUID, body waldurclient.OpenstackBackupsRestoreJSONRequestBody, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackBackupsRestoreResponse, error)
+	OpenstackInstanceAvailabilityZonesListWithResponse(ctx context.Context, params *waldurclient.OpenstackInstanceAvailabilityZonesListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstanceAvailabilityZonesListResponse, error)
+	OpenstackSubnetsListWithResponse(ctx context.Context, params *waldurclient.OpenstackSubnetsListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackSubnetsListResponse, error)
+	OpenstackSubnetsRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.OpenstackSubnetsRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackSubnetsRetrieveResponse, error)
+	OpenstackInstancesListWithResponse(ctx context.Context, params *waldurclient.OpenstackInstancesListParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesListResponse, error)
+	OpenstackInstancesRetrieveWithResponse(ctx context.Context, uuid uuid.UUID, params *waldurclient.OpenstackInstancesRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesRetrieveResponse, error)
+	OpenstackInstancesStartWithResponse(ctx context.Context, uuid uuid.UUID, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesStartResponse, error)
+	OpenstackInstancesStopWithResponse(ctx context.Context, uuid uuid.UUID, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesStopResponse, error)
+	OpenstackInstancesRestartWithResponse(ctx context.Context, uuid uuid.UUID, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesRestartResponse, error)
+	OpenstackInstancesChangeFlavorWithResponse(ctx context.Context, uuid uuid.UUID, body waldurclient.OpenstackInstancesChangeFlavorJSONRequestBody, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesChangeFlavorResponse, error)
+	OpenstackInstancesShelveWithResponse(ctx context.Context, uuid uuid.UUID, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesShelveResponse, error)
+	OpenstackInstancesUnshelveWithResponse(ctx context.Context, uuid uuid.UUID, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesUnshelveResponse, error)
+	OpenstackInstancesSuspendWithResponse(ctx context.Context, uuid uuid.UUID, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesSuspendResponse, error)
+	OpenstackInstancesResumeWithResponse(ctx context.Context, uuid uuid.UUID, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesResumeResponse, error)
+}
+
+var _ WaldurAPI = (*waldurclient.ClientWithResponses)(nil)
+
+// newWaldurClient creates the generated Waldur client authenticated with the API token
+func newWaldurClient(apiUrl, apiToken string) (*waldurclient.ClientWithResponses, error) {
+	hc := http.Client{}
+	auth, err := waldurclient.NewTokenAuth(apiToken)
+	if err != nil {
+		log.Errorf("Error while creating token auth %s", err)
+		return nil, err
+	}
+
+	client, err := waldurclient.NewClientWithResponses(apiUrl, waldurclient.WithHTTPClient(&hc), waldurclient.WithRequestEditorFn(auth.Intercept))
+	if err != nil {
+		log.Errorf("Error creating Waldur client %s", err)
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// getWaldurClient returns the injected client or creates the default one
+func (d *Driver) getWaldurClient() (WaldurAPI, error) {
+	if d.Client != nil {
+		return d.Client, nil
+	}
+	token, err := d.apiToken()
+	if err != nil {
+		log.Errorf("Error reading Waldur API token %s", err)
+		return nil, err
+	}
+	return newWaldurClient(d.ApiUrl, token)
+}
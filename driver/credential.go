@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// redactedToken replaces the API token in dumps of the driver
+const redactedToken = "[REDACTED]"
+
+// validateTokenSource checks that the token comes from exactly one source
+func validateTokenSource(token, tokenFile, tokenEnv string) error {
+	sources := 0
+	for _, source := range []string{token, tokenFile, tokenEnv} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources == 0 {
+		return fmt.Errorf("Waldur requires the --waldur-api-token, --waldur-api-token-file or --waldur-api-token-env option")
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of --waldur-api-token, --waldur-api-token-file and --waldur-api-token-env can be set")
+	}
+	return nil
+}
+
+// tokenExternal tells whether the token is read from a file or the environment at each
+// operation rather than kept in the machine config
+func (d *Driver) tokenExternal() bool {
+	return d.ApiTokenFile != "" || d.ApiTokenEnv != ""
+}
+
+// apiToken returns the API token, reading it from its file or environment variable when configured
+func (d *Driver) apiToken() (string, error) {
+	switch {
+	case d.ApiTokenFile != "":
+		data, err := os.ReadFile(d.ApiTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read the Waldur API token: %w", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("the Waldur API token file %s is empty", d.ApiTokenFile)
+		}
+		return token, nil
+	case d.ApiTokenEnv != "":
+		token := strings.TrimSpace(os.Getenv(d.ApiTokenEnv))
+		if token == "" {
+			return "", fmt.Errorf("the environment variable %s holding the Waldur API token is not set", d.ApiTokenEnv)
+		}
+		return token, nil
+	}
+	return d.ApiToken, nil
+}
+
+// redact replaces the API token in the text, e.g. an error message about to be logged
+func (d *Driver) redact(text string) string {
+	token, err := d.apiToken()
+	if err != nil || token == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, token, redactedToken)
+}
+
+// driverFields has the fields of Driver without its methods, so that they can be
+// marshalled and formatted without recursion
+type driverFields Driver
+
+// MarshalJSON persists the machine config, leaving out the token when it is read from a file
+// or the environment so that the machine store never holds it
+func (d *Driver) MarshalJSON() ([]byte, error) {
+	fields := driverFields(*d)
+	if d.tokenExternal() {
+		fields.ApiToken = ""
+	}
+	return json.Marshal(&fields)
+}
+
+// Format prints the driver with the token redacted, covering %v, %+v and %#v dumps of
+// both the driver and pointers to it, hence the value receiver
+func (d Driver) Format(f fmt.State, verb rune) {
+	fields := driverFields(d)
+	if fields.ApiToken != "" {
+		fields.ApiToken = redactedToken
+	}
+	fmt.Fprintf(f, fmt.FormatString(f, verb), &fields)
+}
@@ -0,0 +1,118 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateTokenSource(t *testing.T) {
+	if err := validateTokenSource("token", "", ""); err != nil {
+		t.Errorf("unexpected error for a token: %v", err)
+	}
+	if err := validateTokenSource("", "/run/secrets/waldur", ""); err != nil {
+		t.Errorf("unexpected error for a token file: %v", err)
+	}
+	if err := validateTokenSource("", "", ""); err == nil {
+		t.Error("expected an error without a token")
+	}
+	if err := validateTokenSource("token", "", "WALDUR_TOKEN"); err == nil {
+		t.Error("expected an error for two token sources")
+	}
+}
+
+func TestAPITokenSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDriver("node-1", "")
+	d.ApiTokenFile = path
+	if token, err := d.apiToken(); err != nil || token != "file-token" {
+		t.Errorf("expected the file token, got %q, %v", token, err)
+	}
+
+	// The file is read at each operation, so a rotated token is picked up
+	if err := os.WriteFile(path, []byte("rotated-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := d.apiToken(); token != "rotated-token" {
+		t.Errorf("expected the rotated token, got %q", token)
+	}
+
+	d.ApiTokenFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := d.apiToken(); err == nil {
+		t.Error("expected an error for a missing token file")
+	}
+
+	d.ApiTokenFile = ""
+	d.ApiTokenEnv = "WALDUR_TEST_TOKEN"
+	if _, err := d.apiToken(); err == nil {
+		t.Error("expected an error for an unset variable")
+	}
+	t.Setenv("WALDUR_TEST_TOKEN", "env-token")
+	if token, err := d.apiToken(); err != nil || token != "env-token" {
+		t.Errorf("expected the variable token, got %q, %v", token, err)
+	}
+}
+
+func TestExternalTokenIsNotPersisted(t *testing.T) {
+	t.Setenv("WALDUR_TEST_TOKEN", "env-token")
+	d := NewDriver("node-1", "")
+	d.ApiUrl = "https://waldur.example.com/"
+	d.ApiToken = "env-token"
+	d.ApiTokenEnv = "WALDUR_TEST_TOKEN"
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "env-token") {
+		t.Errorf("the token is persisted: %s", data)
+	}
+	restored := NewDriver("", "")
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.MachineName != "node-1" || restored.ApiUrl != d.ApiUrl || restored.ApiTokenEnv != d.ApiTokenEnv {
+		t.Errorf("unexpected restored driver %+v", restored)
+	}
+	if token, err := restored.apiToken(); err != nil || token != "env-token" {
+		t.Errorf("expected the restored driver to read the variable, got %q, %v", token, err)
+	}
+
+	// Tokens given inline are still persisted, rancher-machine needs them for later operations
+	inline := NewDriver("node-1", "")
+	inline.ApiToken = "inline-token"
+	if data, err := json.Marshal(inline); err != nil || !strings.Contains(string(data), `"ApiToken":"inline-token"`) {
+		t.Errorf("expected the inline token to be persisted, got %s, %v", data, err)
+	}
+}
+
+func TestDriverDumpsRedactToken(t *testing.T) {
+	d := NewDriver("node-1", "")
+	d.ApiToken = "secret-token"
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+		for _, value := range []any{d, *d} {
+			dump := fmt.Sprintf(verb, value)
+			if strings.Contains(dump, "secret-token") || !strings.Contains(dump, redactedToken) {
+				t.Errorf("%s dump does not redact the token: %s", verb, dump)
+			}
+		}
+	}
+	if d.ApiToken != "secret-token" {
+		t.Error("formatting should not change the token")
+	}
+}
+
+func TestAPIErrorRedactsToken(t *testing.T) {
+	d := NewDriver("node-1", "")
+	d.ApiToken = "secret-token"
+	err := d.apiError("order creation", "", 400, []byte(`{"token": ["secret-token is not valid here"]}`))
+	if strings.Contains(err.Error(), "secret-token") || strings.Contains(err.(*APIError).Body, "secret-token") {
+		t.Errorf("the error exposes the token: %v", err)
+	}
+}
@@ -2,9 +2,7 @@ package driver
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -18,8 +16,10 @@ import (
 	waldurclient "github.com/waldur/go-client"
 )
 
-const (
-	driverName           = "waldur"
+const driverName = "waldur"
+
+// Polling settings are variables so that tests can shorten them
+var (
 	creationPollInterval = 10 * time.Second
 	creationPollTimeout  = 20 * time.Minute
 )
@@ -27,19 +27,57 @@ const (
 type Driver struct {
 	*drivers.BaseDriver
 
-	ApiUrl               string
-	ApiToken             string
-	ProjectUuid          string
-	OfferingUuid         string
-	FlavorUuid           string
-	ImageUuid            string
-	SystemVolumeSize     int
-	SystemVolumeTypeUuid string
-	DataVolumeTypeUuid   string
-	SubnetUuids          []string
-	SecurityGroupUuid    string
-	ResourceUuid         string
-	UserData             string
+	ApiUrl                 string
+	ApiToken               string
+	ApiTokenFile           string
+	ApiTokenEnv            string
+	ProjectUuid            string
+	OfferingUuid           string
+	FlavorUuid             string
+	ImageUuid              string
+	Source                 string
+	SourceUuid             string
+	SystemVolumeSize       int
+	SystemVolumeTypeUuid   string
+	DataVolumeTypeUuid     string
+	DataVolumeSize         int
+	SubnetUuids            []string
+	Ports                  []PortSpec
+	SecurityGroupUuid      string
+	SecurityGroupUuids     []string
+	SecurityGroupAuto      bool
+	SecurityGroupCidr      string
+	SecurityGroupAutoUuid  string
+	ClusterName            string
+	ResourceUuid           string
+	UserData               string
+	CheckQuotas            bool
+	PlanUuid               string
+	PlanName               string
+	PlanUrl                string
+	Limits                 map[string]int
+	OrderAttributes        map[string]any
+	AvailabilityZone       string
+	AvailabilityZoneSpread bool
+	ServerGroup            string
+	ServerGroupAuto        bool
+	ServerGroupUuid        string
+	NodePool               string
+	StopMode               string
+	SnapshotOnRemove       string
+	ImportResourceUuid     string
+	ImportSSHKeyPath       string
+	ImportedDescription    string
+	Creator                string
+	NameTemplate           string
+	NameUnique             bool
+	ResourceName           string
+
+	// Client overrides the Waldur API client, e.g. with a mock or a wrapper
+	Client WaldurAPI `json:"-"`
+
+	// Metrics receives the outcome of Waldur operations, operations are logged when nil
+	Metrics OperationMetrics `json:"-"`
 }
 
 // NewDriver creates and returns a new instance of Waldur driver
@@ -64,7 +102,17 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			EnvVar: "WALDUR_API_TOKEN",
 			Name:   "waldur-api-token",
-			Usage:  "Waldur API URL",
+			Usage:  "Waldur API token",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_API_TOKEN_FILE",
+			Name:   "waldur-api-token-file",
+			Usage:  "File holding the Waldur API token, read at each operation and never stored with the machine",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_API_TOKEN_ENV",
+			Name:   "waldur-api-token-env",
+			Usage:  "Environment variable holding the Waldur API token at each operation, e.g. one set from a Rancher cloud credential; the token is never stored with the machine",
 		},
 		mcnflag.StringFlag{
 			EnvVar: "WALDUR_PROJ_UUID",
@@ -86,6 +134,17 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "waldur-image-uuid",
 			Usage:  "UUID of the VM image in Waldur",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_SOURCE",
+			Name:   "waldur-source",
+			Usage:  "Boot source of the instance: image, or backup to restore an instance backup without a marketplace order. Volume snapshots are not supported, Waldur cannot boot from them",
+			Value:  sourceImage,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_SOURCE_UUID",
+			Name:   "waldur-source-uuid",
+			Usage:  "UUID of the instance backup to boot from",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "WALDUR_SYS_VOLUME_SIZE",
 			Name:   "waldur-sys-volume-size",
@@ -106,16 +165,133 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "waldur-sec-group-uuid",
 			Usage:  "UUID of the security group in Waldur",
 		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "WALDUR_SEC_GROUP_UUIDS",
+			Name:   "waldur-sec-group-uuids",
+			Usage:  "List of UUIDs of additional security groups in Waldur",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "WALDUR_SEC_GROUP_AUTO",
+			Name:   "waldur-sec-group-auto",
+			Usage:  "Attach a per-cluster security group opening the Rancher node ports, created on first use and deleted when unused",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_SEC_GROUP_CIDR",
+			Name:   "waldur-sec-group-cidr",
+			Usage:  "CIDR the Rancher node ports of the per-cluster security group are opened to, required with --waldur-sec-group-auto",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_CLUSTER_NAME",
+			Name:   "waldur-cluster-name",
+			Usage:  "Name of the Rancher cluster, used to name the per-cluster security group and the per-pool server groups, required by --waldur-sec-group-auto and --waldur-server-group-auto",
+		},
 		mcnflag.StringSliceFlag{
 			EnvVar: "WALDUR_SUBNET_UUIDS",
 			Name:   "waldur-subnet-uuids",
 			Usage:  "List of UUIDs of subnets in Waldur",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_PORTS",
+			Name:   "waldur-ports",
+			Usage:  "JSON or YAML list of ports with subnet or port UUID and optional fixed_ips, replacing --waldur-subnet-uuids. port_security is rejected, Waldur's instance order does not accept it",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "WALDUR_USER_DATA",
 			Name:   "waldur-user-data",
 			Usage:  "User data, possibly script to be executed on instance creation",
 		},
+		mcnflag.BoolFlag{
+			EnvVar: "WALDUR_CHECK_QUOTAS",
+			Name:   "waldur-check-quotas",
+			Usage:  "Check tenant and project quotas against the flavor and volume sizes before creation",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_PLAN_UUID",
+			Name:   "waldur-plan-uuid",
+			Usage:  "UUID of the offering plan in Waldur",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_PLAN_NAME",
+			Name:   "waldur-plan-name",
+			Usage:  "Name of the offering plan in Waldur, used when no plan UUID is given",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "WALDUR_LIMIT",
+			Name:   "waldur-limit",
+			Usage:  "Offering component limit as key=value, may be repeated",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_ORDER_ATTRIBUTES",
+			Name:   "waldur-order-attributes",
+			Usage:  "JSON or YAML mapping of extra order attributes, merged over the ones set by the driver",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_AVAILABILITY_ZONE",
+			Name:   "waldur-availability-zone",
+			Usage:  "Name or UUID of the instance availability zone in Waldur",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "WALDUR_AVAILABILITY_ZONE_SPREAD",
+			Name:   "waldur-availability-zone-spread",
+			Usage:  "Spread machines over the tenant's availability zones by hashing the machine name",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_SERVER_GROUP",
+			Name:   "waldur-server-group",
+			Usage:  "Name or UUID of the server group in Waldur the instance is placed in",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "WALDUR_SERVER_GROUP_AUTO",
+			Name:   "waldur-server-group-auto",
+			Usage:  "Place instances in an anti-affinity server group per cluster and node pool, created on first use and deleted with the last member",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_NODE_POOL",
+			Name:   "waldur-node-pool",
+			Usage:  "Name of the Rancher node pool, required by --waldur-server-group-auto",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_CREATOR",
+			Name:   "waldur-creator",
+			Usage:  "Who creates the machine, recorded in the description of its resource",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_NAME_TEMPLATE",
+			Name:   "waldur-name-template",
+			Usage:  "Template of the resource name referring to {{.Machine}}, e.g. {{.Project}}-{{.Cluster}}-{{.Machine}}, sanitised to a hostname; also accepts {{.NodePool}}",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "WALDUR_NAME_UNIQUE",
+			Name:   "waldur-name-unique",
+			Usage:  "Fail when a resource of the project already has the name",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_STOP_MODE",
+			Name:   "waldur-stop-mode",
+			Usage:  "How Stop powers off the instance: stop, shelve to release its compute quota, or suspend",
+			Value:  stopModeStop,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_SNAPSHOT_ON_REMOVE",
+			Name:   "waldur-snapshot-on-remove",
+			Usage:  "Preserve the instance before removal: snapshot to snapshot its volumes, or backup for a Waldur backup. Its volumes are kept and listed for cleanup in a manifest under waldur-removals in the store path",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_IMPORT_RESOURCE_UUID",
+			Name:   "waldur-import-resource-uuid",
+			Usage:  "UUID of an existing Waldur instance resource to adopt instead of ordering one. Its description is replaced by the driver's, which keeps the original, and gc -terminate then treats it as created by the driver",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_IMPORT_SSH_KEY_PATH",
+			Name:   "waldur-import-ssh-key-path",
+			Usage:  "Private SSH key granting access to the adopted instance, used once to install the machine's key",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "WALDUR_IMPORT_SSH_USER",
+			Name:   "waldur-import-ssh-user",
+			Usage:  "User the adopted instance is accessed as",
+		},
 	}
 }
 
@@ -123,78 +299,156 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.ApiUrl = flags.String("waldur-api-url")
 	d.ApiToken = flags.String("waldur-api-token")
+	d.ApiTokenFile = flags.String("waldur-api-token-file")
+	d.ApiTokenEnv = flags.String("waldur-api-token-env")
 	d.ProjectUuid = flags.String("waldur-proj-uuid")
 	d.OfferingUuid = flags.String("waldur-offering-uuid")
 	d.FlavorUuid = flags.String("waldur-flavor-uuid")
 	d.ImageUuid = flags.String("waldur-image-uuid")
+	d.Source = flags.String("waldur-source")
+	d.SourceUuid = flags.String("waldur-source-uuid")
 	d.SystemVolumeSize = flags.Int("waldur-sys-volume-size")
 	d.SystemVolumeTypeUuid = flags.String("waldur-sys-volume-type-uuid")
 	d.DataVolumeTypeUuid = flags.String("waldur-data-volume-type-uuid")
 	d.SecurityGroupUuid = flags.String("waldur-sec-group-uuid")
+	d.SecurityGroupUuids = flags.StringSlice("waldur-sec-group-uuids")
+	d.SecurityGroupAuto = flags.Bool("waldur-sec-group-auto")
+	d.SecurityGroupCidr = flags.String("waldur-sec-group-cidr")
+	d.ClusterName = flags.String("waldur-cluster-name")
 	d.SubnetUuids = flags.StringSlice("waldur-subnet-uuids")
 	d.UserData = flags.String("waldur-user-data")
+	d.CheckQuotas = flags.Bool("waldur-check-quotas")
+	d.PlanUuid = flags.String("waldur-plan-uuid")
+	d.PlanName = flags.String("waldur-plan-name")
 
 	// Validation
 	if d.ApiUrl == "" {
 		return fmt.Errorf("Waldur requires the --waldur-api-url option")
 	}
 
-	if d.ApiToken == "" {
-		return fmt.Errorf("Waldur requires the --waldur-api-token option")
+	if err := validateTokenSource(d.ApiToken, d.ApiTokenFile, d.ApiTokenEnv); err != nil {
+		return err
+	}
+	if _, err := d.apiToken(); err != nil {
+		return err
 	}
 
 	if d.ProjectUuid == "" {
 		return fmt.Errorf("Waldur requires the --waldur-proj-uuid option")
 	}
-	if d.OfferingUuid == "" {
-		return fmt.Errorf("Waldur requires the --waldur-offering-uuid option")
+	d.ImportResourceUuid = flags.String("waldur-import-resource-uuid")
+	d.ImportSSHKeyPath = flags.String("waldur-import-ssh-key-path")
+	if importUser := flags.String("waldur-import-ssh-user"); importUser != "" {
+		d.SSHUser = importUser
 	}
-	if d.FlavorUuid == "" {
-		return fmt.Errorf("Waldur requires the --waldur-flavor-uuid option")
-	}
-	if d.ImageUuid == "" {
-		return fmt.Errorf("Waldur requires the --waldur-image-uuid option")
-	}
-	if d.SystemVolumeSize == 0 {
-		return fmt.Errorf("Waldur requires the --waldur-sys-volume-size to be greater than 5 GB")
-	}
-	if d.SystemVolumeTypeUuid == "" {
-		return fmt.Errorf("Waldur requires the --waldur-sys-volume-type-uuid option")
-	}
-	if d.DataVolumeTypeUuid == "" {
-		return fmt.Errorf("Waldur requires the --waldur-data-volume-type-uuid option")
-	}
-	if d.SecurityGroupUuid == "" {
-		return fmt.Errorf("Waldur requires the --waldur-sec-group-uuid option")
+	if d.ImportResourceUuid != "" {
+		if err := validateImport(d.ImportResourceUuid, d.ImportSSHKeyPath); err != nil {
+			return err
+		}
+	} else {
+		// An adopted instance is not ordered, so the ordering options are only required otherwise
+		if d.OfferingUuid == "" {
+			return fmt.Errorf("Waldur requires the --waldur-offering-uuid option")
+		}
+		if d.FlavorUuid == "" {
+			return fmt.Errorf("Waldur requires the --waldur-flavor-uuid option")
+		}
+		if err := validateSource(d.Source, d.SourceUuid, d.ImageUuid); err != nil {
+			return err
+		}
+		if d.SystemVolumeSize == 0 {
+			return fmt.Errorf("Waldur requires the --waldur-sys-volume-size to be greater than 5 GB")
+		}
+		if d.SystemVolumeTypeUuid == "" {
+			return fmt.Errorf("Waldur requires the --waldur-sys-volume-type-uuid option")
+		}
+		if d.DataVolumeTypeUuid == "" {
+			return fmt.Errorf("Waldur requires the --waldur-data-volume-type-uuid option")
+		}
+		if len(d.securityGroupUuids()) == 0 && !d.SecurityGroupAuto {
+			return fmt.Errorf("Waldur requires the --waldur-sec-group-uuid, --waldur-sec-group-uuids or --waldur-sec-group-auto option")
+		}
+		if err := validateAutoSecurityGroup(d.SecurityGroupAuto, d.ClusterName, d.SecurityGroupCidr); err != nil {
+			return err
+		}
 	}
 	if d.SubnetUuids == nil {
 		d.SubnetUuids = []string{}
 	}
+	ports, err := parsePorts(flags.String("waldur-ports"))
+	if err != nil {
+		return err
+	}
+	if len(ports) > 0 && len(d.SubnetUuids) > 0 {
+		return fmt.Errorf("Waldur accepts either --waldur-subnet-uuids or --waldur-ports")
+	}
+	d.Ports = ports
 	if d.UserData == "" {
 		log.Warn("No user data provided")
 	}
-
-	return nil
-}
-
-func (d *Driver) getWaldurClient() (*waldurclient.ClientWithResponses, error) {
-	hc := http.Client{}
-	auth, err := waldurclient.NewTokenAuth(d.ApiToken)
+	limits, err := parseLimits(flags.StringSlice("waldur-limit"))
 	if err != nil {
-		log.Errorf("Error while creating token auth %s", err)
-		return nil, err
+		return err
 	}
-
-	client, err := waldurclient.NewClientWithResponses(d.ApiUrl, waldurclient.WithHTTPClient(&hc), waldurclient.WithRequestEditorFn(auth.Intercept))
+	d.Limits = limits
+	orderAttributes, err := parseOrderAttributes(flags.String("waldur-order-attributes"))
 	if err != nil {
-		log.Errorf("Error creating Waldur client %s", err)
-		return nil, err
+		return err
+	}
+	d.OrderAttributes = orderAttributes
+	d.AvailabilityZone = flags.String("waldur-availability-zone")
+	d.AvailabilityZoneSpread = flags.Bool("waldur-availability-zone-spread")
+	if d.AvailabilityZone != "" && d.AvailabilityZoneSpread {
+		return fmt.Errorf("Waldur accepts either --waldur-availability-zone or --waldur-availability-zone-spread")
+	}
+	d.ServerGroup = flags.String("waldur-server-group")
+	d.ServerGroupAuto = flags.Bool("waldur-server-group-auto")
+	d.NodePool = flags.String("waldur-node-pool")
+	d.Creator = flags.String("waldur-creator")
+	d.NameTemplate = flags.String("waldur-name-template")
+	d.NameUnique = flags.Bool("waldur-name-unique")
+	if d.NameTemplate != "" {
+		if _, err := parseNameTemplate(d.NameTemplate); err != nil {
+			return err
+		}
+	}
+	if d.ServerGroup != "" && d.ServerGroupAuto {
+		return fmt.Errorf("Waldur accepts either --waldur-server-group or --waldur-server-group-auto")
+	}
+	if d.ServerGroupAuto && d.NodePool == "" {
+		// Rancher machine names end in random suffixes, the pool cannot be derived from them
+		return fmt.Errorf("Waldur requires the --waldur-node-pool option with --waldur-server-group-auto")
+	}
+	if d.ServerGroupAuto && d.ClusterName == "" {
+		// pools of different clusters in a tenant would otherwise share one group
+		return fmt.Errorf("Waldur requires the --waldur-cluster-name option with --waldur-server-group-auto")
+	}
+	d.StopMode = flags.String("waldur-stop-mode")
+	if err := validateStopMode(d.StopMode); err != nil {
+		return err
+	}
+	d.SnapshotOnRemove = flags.String("waldur-snapshot-on-remove")
+	if err := validateSnapshotMode(d.SnapshotOnRemove); err != nil {
+		return err
+	}
+	if d.ImportResourceUuid == "" && d.Source == sourceBackup {
+		if err := d.validateBackupSource(); err != nil {
+			return err
+		}
 	}
 
-	return client, nil
+	return nil
+}
+
+// apiError logs the response of a failed Waldur API call and returns it as an *APIError
+func (d *Driver) apiError(operation, resourceUuid string, statusCode int, body []byte) error {
+	// Error bodies may echo request values, the token never reaches the logs
+	apiErr := newAPIError(operation, resourceUuid, statusCode, []byte(d.redact(string(body))))
+	log.Errorf("Waldur %s failed for %s (%s), code %d, details: %s", operation, d.GetMachineName(), resourceUuid, statusCode, apiErr.Body)
+	return apiErr
 }
 
-func (d *Driver) getWaldurResource(client waldurclient.ClientWithResponses) (*waldurclient.Resource, error) {
+func (d *Driver) getWaldurResource(client WaldurAPI) (*waldurclient.Resource, error) {
 	ctx := context.Background()
 	resourceUuid, err := uuid.Parse(d.ResourceUuid)
 	if err != nil {
@@ -209,10 +463,7 @@ func (d *Driver) getWaldurResource(client waldurclient.ClientWithResponses) (*wa
 	}
 
 	if resp.StatusCode() != 200 {
-		responseBody := string(resp.Body[:])
-		log.Errorf("Unable to fetch the instance %s (%s), code %d, details: %s", d.GetMachineName(), d.ResourceUuid, resp.StatusCode(), responseBody)
-		msg := fmt.Sprintf("Unable to fetch the instance %s (%s), code %d", d.GetMachineName(), d.ResourceUuid, resp.StatusCode())
-		return nil, errors.New(msg)
+		return nil, d.apiError("resource retrieval", d.ResourceUuid, resp.StatusCode(), resp.Body)
 	}
 
 	log.Infof("Successfully fetched resource %s (%s)", d.GetMachineName(), d.ResourceUuid)
@@ -220,6 +471,28 @@ func (d *Driver) getWaldurResource(client waldurclient.ClientWithResponses) (*wa
 	return resp.JSON200, nil
 }
 
+// getWaldurInstance fetches the OpenStack instance backing the machine's resource
+func (d *Driver) getWaldurInstance(client WaldurAPI) (*waldurclient.OpenStackInstance, error) {
+	resource, err := d.getWaldurResource(client)
+	if err != nil {
+		return nil, err
+	}
+	if resource.ResourceUuid == nil {
+		return nil, fmt.Errorf("resource %s of %s has no backend instance", d.ResourceUuid, d.GetMachineName())
+	}
+
+	ctx := context.Background()
+	resp, err := client.OpenstackInstancesRetrieveWithResponse(ctx, *resource.ResourceUuid, &waldurclient.OpenstackInstancesRetrieveParams{})
+	if err != nil {
+		log.Errorf("Error calling instance retrieval API: %v", err)
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, d.apiError("instance retrieval", d.ResourceUuid, resp.StatusCode(), resp.Body)
+	}
+	return resp.JSON200, nil
+}
+
 // Create creates a host in Waldur using the driver's config
 func (d *Driver) Create() error {
 	log.Infof("Creating instance for %s...", d.GetMachineName())
@@ -232,43 +505,72 @@ func (d *Driver) Create() error {
 		return fmt.Errorf("failed to read SSH public key: %w", err)
 	}
 
+	client, err := d.getWaldurClient()
+	if err != nil {
+		log.Errorf("Error creating Waldur client %s", err)
+		return err
+	}
+
+	if d.ImportResourceUuid != "" {
+		return d.importInstance(client, publicKey)
+	}
+	if err := d.resolveResourceName(client); err != nil {
+		return err
+	}
+
 	projectUri := fmt.Sprintf("%s/api/projects/%s/", d.ApiUrl, d.ProjectUuid)
 	offeringUri := fmt.Sprintf("%s/api/marketplace-public-offerings/%s/", d.ApiUrl, d.OfferingUuid)
 	flavorUri := fmt.Sprintf("%s/api/openstack-flavors/%s/", d.ApiUrl, d.FlavorUuid)
 	imageUri := fmt.Sprintf("%s/api/openstack-images/%s/", d.ApiUrl, d.ImageUuid)
 	systemVolumeTypeUri := fmt.Sprintf("%s/api/openstack-volume-types/%s/", d.ApiUrl, d.SystemVolumeTypeUuid)
 	dataVolumeTypeUri := fmt.Sprintf("%s/api/openstack-volume-types/%s/", d.ApiUrl, d.DataVolumeTypeUuid)
-	subnets := make([]waldurclient.OpenStackCreateInstancePortRequest, len(d.SubnetUuids))
-	defaultSecGroupUri := fmt.Sprintf("%s/api/openstack-security-groups/%s/", d.ApiUrl, d.SecurityGroupUuid)
-	securityGroups := make([]waldurclient.OpenStackSecurityGroupHyperlinkRequest, 1)
-	securityGroups[0] = waldurclient.OpenStackSecurityGroupHyperlinkRequest{
-		Url: defaultSecGroupUri,
+	securityGroups, err := d.resolveSecurityGroups(client)
+	if err != nil {
+		return err
 	}
 
-	for i, subnet := range d.SubnetUuids {
-		subnetUri := fmt.Sprintf("%s/api/openstack-subnets/%s/", d.ApiUrl, subnet)
-		subnets[i] = waldurclient.OpenStackCreateInstancePortRequest{
-			Subnet: &subnetUri,
-		}
+	if err := d.resolveSubnets(client); err != nil {
+		return err
+	}
+	ports, err := d.buildPorts(client)
+	if err != nil {
+		return err
 	}
 
 	systemVolumeSizeMB := d.SystemVolumeSize * 1024
 	userData := d.buildUserData(publicKey)
 
+	if d.Source == sourceBackup {
+		return d.restoreBackup(client, flavorUri, securityGroups, ports, userData)
+	}
+
+	description := d.resourceDescription()
 	osInstanceOrderAttributes := waldurclient.OpenStackInstanceCreateOrderAttributes{
-		Name:             d.GetMachineName(),
+		Name:             d.ResourceName,
 		Flavor:           &flavorUri,
 		Image:            &imageUri,
 		SystemVolumeSize: &systemVolumeSizeMB,
 		SystemVolumeType: &systemVolumeTypeUri,
 		DataVolumeType:   &dataVolumeTypeUri,
-		Ports:            &subnets,
+		Ports:            &ports,
 		SecurityGroups:   &securityGroups,
 		UserData:         &userData,
+		Description:      &description,
 	}
 
-	attributes := waldurclient.OrderCreateRequest_Attributes{}
-	err = attributes.FromOpenStackInstanceCreateOrderAttributes(osInstanceOrderAttributes)
+	availabilityZoneUri, err := d.resolveAvailabilityZone(client)
+	if err != nil {
+		return err
+	}
+	osInstanceOrderAttributes.AvailabilityZone = availabilityZoneUri
+
+	serverGroupUri, err := d.resolveServerGroup(client)
+	if err != nil {
+		return err
+	}
+	osInstanceOrderAttributes.ServerGroup = serverGroupUri
+
+	attributes, err := d.buildOrderAttributes(osInstanceOrderAttributes)
 
 	if err != nil {
 		log.Errorf("Error creating order attributes %s", err)
@@ -278,11 +580,22 @@ func (d *Driver) Create() error {
 	acceptingTermsOfService := true
 
 	limits := map[string]int{}
+	for key, value := range d.Limits {
+		limits[key] = value
+	}
 
-	client, err := d.getWaldurClient()
-	if err != nil {
-		log.Errorf("Error creating Waldur client %s", err)
-		return err
+	// The plan is usually resolved by PreCreateCheck, which drivers loaded from older stores
+	// and direct callers may not have run
+	if d.PlanUrl == "" && (d.PlanUuid != "" || d.PlanName != "") {
+		resolved, err := d.resolveOrderPlan(client)
+		if err != nil {
+			return err
+		}
+		d.PlanUrl = stringOf(resolved)
+	}
+	var planUri *string
+	if d.PlanUrl != "" {
+		planUri = &d.PlanUrl
 	}
 	requestType := waldurclient.Create
 
@@ -291,6 +604,7 @@ func (d *Driver) Create() error {
 		Attributes:              &attributes,
 		Limits:                  &limits,
 		Offering:                offeringUri,
+		Plan:                    planUri,
 		Project:                 projectUri,
 		Type:                    &requestType,
 	}
@@ -304,16 +618,15 @@ func (d *Driver) Create() error {
 	}
 
 	if resp.StatusCode() != 201 {
-		responseBody := string(resp.Body[:])
-		log.Errorf("Unable to create an instance %s, code %d, details: %s", d.GetMachineName(), resp.StatusCode(), responseBody)
-		msg := fmt.Sprintf("Unable to create an instance %s, code %d", d.GetMachineName(), resp.StatusCode())
-		return errors.New(msg)
+		return asQuotaError(d.apiError("order creation", "", resp.StatusCode(), resp.Body))
 	}
 
 	log.Infof("Successfully submitted order for instance %s", d.GetMachineName())
 
 	d.ResourceUuid = resp.JSON201.ResourceUuid.String()
 	log.Infof("Resource UUID: %s", d.ResourceUuid)
+	// The order description describes the instance, the resource gets it through its own API
+	d.describeResource(client)
 
 	if err := d.waitForActive(client); err != nil {
 		return err
@@ -347,52 +660,22 @@ func (d *Driver) buildUserData(publicKey []byte) string {
 
 // waitForActive polls the Waldur API until the provisioned VM reaches ACTIVE state,
 // then extracts its IP address into d.IPAddress.
-func (d *Driver) waitForActive(client *waldurclient.ClientWithResponses) error {
-	deadline := time.Now().Add(creationPollTimeout)
-	for {
-		if time.Now().After(deadline) {
-			return fmt.Errorf("timed out waiting for instance %s to become active after %v", d.GetMachineName(), creationPollTimeout)
-		}
-
-		resource, err := d.getWaldurResource(*client)
-		if err != nil {
-			return err
-		}
-
-		if resource.State != nil && *resource.State == waldurclient.ResourceStateErred {
-			errMsg := ""
-			if resource.ErrorMessage != nil {
-				errMsg = *resource.ErrorMessage
-			}
-			return fmt.Errorf("instance %s entered error state: %s", d.GetMachineName(), errMsg)
-		}
-
-		if resource.BackendMetadata != nil &&
-			resource.BackendMetadata.RuntimeState != nil &&
-			*resource.BackendMetadata.RuntimeState == "ACTIVE" {
-			break
-		}
-
-		runtimeState := "unknown"
-		if resource.BackendMetadata != nil && resource.BackendMetadata.RuntimeState != nil {
-			runtimeState = *resource.BackendMetadata.RuntimeState
-		}
-		log.Infof("Instance %s runtime state: %s — waiting...", d.GetMachineName(), runtimeState)
-		time.Sleep(creationPollInterval)
-	}
-
-	resourceUUID, err := uuid.Parse(d.ResourceUuid)
+func (d *Driver) waitForActive(client WaldurAPI) error {
+	resource, err := d.waitForRuntimeState(client, creationPollInterval, creationPollTimeout, "ACTIVE")
 	if err != nil {
-		return fmt.Errorf("failed to parse resource UUID: %w", err)
+		return err
+	}
+	if resource.ResourceUuid == nil {
+		return fmt.Errorf("resource %s of %s has no backend instance", d.ResourceUuid, d.GetMachineName())
 	}
 
 	ctx := context.Background()
-	instanceResp, err := client.OpenstackInstancesRetrieveWithResponse(ctx, resourceUUID, &waldurclient.OpenstackInstancesRetrieveParams{})
+	instanceResp, err := client.OpenstackInstancesRetrieveWithResponse(ctx, *resource.ResourceUuid, &waldurclient.OpenstackInstancesRetrieveParams{})
 	if err != nil {
 		return fmt.Errorf("failed to retrieve instance details: %w", err)
 	}
 	if instanceResp.StatusCode() != 200 {
-		return fmt.Errorf("failed to retrieve instance details for %s, code %d", d.GetMachineName(), instanceResp.StatusCode())
+		return d.apiError("instance retrieval", d.ResourceUuid, instanceResp.StatusCode(), instanceResp.Body)
 	}
 
 	instance := instanceResp.JSON200
@@ -408,7 +691,30 @@ func (d *Driver) waitForActive(client *waldurclient.ClientWithResponses) error {
 
 // PreCreateCheck validates parameters and checks if creation is possible
 func (d *Driver) PreCreateCheck() error {
-	return nil
+	if d.ImportResourceUuid != "" {
+		return nil
+	}
+
+	client, err := d.getWaldurClient()
+	if err != nil {
+		log.Errorf("Error creating Waldur client %s", err)
+		return err
+	}
+
+	// Plan and limits are checked before Create generates the SSH key
+	planUri, err := d.resolveOrderPlan(client)
+	if err != nil {
+		return err
+	}
+	d.PlanUrl = stringOf(planUri)
+
+	if err := d.resolveSubnets(client); err != nil {
+		return err
+	}
+	if !d.CheckQuotas {
+		return nil
+	}
+	return d.checkQuotas(client)
 }
 
 // GetURL returns the URL of the docker daemon on the host
@@ -432,7 +738,7 @@ func (d *Driver) GetState() (state.State, error) {
 		return state.None, err
 	}
 
-	resource, err := d.getWaldurResource(*client)
+	resource, err := d.getWaldurResource(client)
 	if err != nil {
 		return state.None, err
 	}
@@ -460,6 +766,14 @@ func (d *Driver) GetState() (state.State, error) {
 		"SHUTOFF":      state.Stopped,
 		"STOPPED":      state.Stopped,
 		"SUSPENDED":    state.Paused,
+		// Shelved instances release their compute resources and are brought back by Start
+		"SHELVED":           state.Stopped,
+		"SHELVED_OFFLOADED": state.Stopped,
+	}
+
+	// Suspending is how Stop powers off in the suspend mode, so rancher-machine has to see it as stopped
+	if resourceStateStr == "SUSPENDED" && d.StopMode == stopModeSuspend {
+		return state.Stopped, nil
 	}
 
 	log.Infof("Instance %s, state %s", d.GetMachineName(), resourceStateStr)
@@ -468,187 +782,112 @@ func (d *Driver) GetState() (state.State, error) {
 
 // Start starts the host
 func (d *Driver) Start() error {
-	log.Infof("Starting instance %s", d.GetMachineName())
 	client, err := d.getWaldurClient()
 	if err != nil {
 		log.Errorf("Error creating Waldur client %s", err)
 		return err
 	}
-
-	resource, err := d.getWaldurResource(*client)
+	resource, err := d.getWaldurResource(client)
 	if err != nil {
 		return err
 	}
 
-	ctx := context.Background()
-	instanceResp, err := client.OpenstackInstancesStartWithResponse(ctx, *resource.ResourceUuid)
-
-	if err != nil {
-		log.Errorf("Error calling instance starting API: %v", err)
-		return err
+	runtimeState := ""
+	if resource.BackendMetadata != nil && resource.BackendMetadata.RuntimeState != nil {
+		runtimeState = *resource.BackendMetadata.RuntimeState
 	}
-
-	if instanceResp.StatusCode() != 202 {
-		responseBody := string(instanceResp.Body[:])
-		log.Errorf("Unable to start the instance %s (%s), code %d, details: %s", d.GetMachineName(), d.ResourceUuid, instanceResp.StatusCode(), responseBody)
-		msg := fmt.Sprintf("Unable to start the instance %s (%s), code %d", d.GetMachineName(), d.ResourceUuid, instanceResp.StatusCode())
-		return errors.New(msg)
+	switch runtimeState {
+	case "SHUTOFF", "STOPPED":
+		// Apply a flavor changed in the machine config while the instance was stopped
+		if err := d.reconcileFlavor(client); err != nil {
+			return err
+		}
 	}
-
-	log.Infof("Successfully started the instance %s", d.GetMachineName())
-
-	return nil
+	return d.runOperation(startOperation(runtimeState))
 }
 
-// Stop stops the host
+// Stop stops the host, shelving or suspending it depending on the stop mode
 func (d *Driver) Stop() error {
-	log.Infof("Stopping the instance %s", d.GetMachineName())
-	client, err := d.getWaldurClient()
-	if err != nil {
-		log.Errorf("Error creating Waldur client %s", err)
-		return err
-	}
-
-	resource, err := d.getWaldurResource(*client)
-	if err != nil {
-		return err
-	}
-
-	ctx := context.Background()
-	instanceResp, err := client.OpenstackInstancesStopWithResponse(ctx, *resource.ResourceUuid)
-
-	if err != nil {
-		log.Errorf("Error calling instance stopping API: %v", err)
-		return err
-	}
-
-	if instanceResp.StatusCode() != 202 {
-		responseBody := string(instanceResp.Body[:])
-		log.Errorf("Unable to stop the instance %s (%s), code %d, details: %s", d.GetMachineName(), d.ResourceUuid, instanceResp.StatusCode(), responseBody)
-		msg := fmt.Sprintf("Unable to stop the instance %s (%s), code %d", d.GetMachineName(), d.ResourceUuid, instanceResp.StatusCode())
-		return errors.New(msg)
-	}
-
-	log.Infof("Successfully stopped the instance %s", d.GetMachineName())
-
-	return nil
+	return d.runOperation(stopOperation(d.StopMode))
 }
 
 // Restart restarts the host
 func (d *Driver) Restart() error {
-	log.Infof("Restarting instance %s", d.GetMachineName())
-	client, err := d.getWaldurClient()
-	if err != nil {
-		log.Errorf("Error creating Waldur client %s", err)
-		return err
-	}
-
-	resource, err := d.getWaldurResource(*client)
-	if err != nil {
-		return err
-	}
-
-	ctx := context.Background()
-	instanceResp, err := client.OpenstackInstancesRestartWithResponse(ctx, *resource.ResourceUuid)
-
-	if err != nil {
-		log.Errorf("Error calling instance restarting API: %v", err)
-		return err
-	}
-
-	if instanceResp.StatusCode() != 202 {
-		responseBody := string(instanceResp.Body[:])
-		log.Errorf("Unable to restart the instance %s (%s), code %d, details: %s", d.GetMachineName(), d.ResourceUuid, instanceResp.StatusCode(), responseBody)
-		msg := fmt.Sprintf("Unable to restart the instance %s (%s), code %d", d.GetMachineName(), d.ResourceUuid, instanceResp.StatusCode())
-		return errors.New(msg)
-	}
-
-	log.Infof("Successfully restarted the instance %s", d.GetMachineName())
-
-	return nil
+	return d.runOperation(operation{
+		name:           "instance restart",
+		onInstance:     true,
+		expectedStatus: 202,
+		call: func(ctx context.Context, client WaldurAPI, id uuid.UUID) (int, []byte, error) {
+			resp, err := client.OpenstackInstancesRestartWithResponse(ctx, id)
+			if err != nil {
+				return 0, nil, err
+			}
+			return resp.StatusCode(), resp.Body, nil
+		},
+	})
 }
 
 // Kill forcefully stops the host
 func (d *Driver) Kill() error {
-	log.Infof("Force removing instance %s", d.GetMachineName())
-	client, err := d.getWaldurClient()
+	err := d.runOperation(terminateOperation("instance force removal", map[string]any{
+		"action": "force_destroy",
+	}))
 	if err != nil {
-		log.Errorf("Error creating Waldur client %s", err)
-		return err
-	}
-
-	ctx := context.Background()
-	resourceUuid, err := uuid.Parse(d.ResourceUuid)
-	if err != nil {
-		log.Errorf("Error converting resource UUID string to UUID object: %s", err)
 		return err
 	}
+	d.cleanupAfterRemoval()
+	return nil
+}
 
-	attributes := map[string]any{
-		"delete_volumes":       true,
-		"release_floating_ips": true,
-		"action":               "force_destroy",
-	}
-	payload := waldurclient.MarketplaceResourcesTerminateJSONRequestBody{
-		Attributes: &attributes,
+// Remove removes the host. With a snapshot mode the instance is snapshotted or backed up
+// first and the removal is aborted when that fails.
+func (d *Driver) Remove() error {
+	// TODO: stop instance prior to removal?
+	var extra map[string]any
+	if d.SnapshotOnRemove != "" {
+		client, err := d.getWaldurClient()
+		if err != nil {
+			log.Errorf("Error creating Waldur client %s", err)
+			return err
+		}
+		manifest, err := d.preserveVolumes(client)
+		if err != nil {
+			return fmt.Errorf("not removing %s, unable to %s it: %w", d.GetMachineName(), d.SnapshotOnRemove, err)
+		}
+		if manifest != nil {
+			// The retained volumes are recorded in the manifest along with how to delete them
+			extra = map[string]any{"delete_volumes": false}
+		}
 	}
-	resp, err := client.MarketplaceResourcesTerminateWithResponse(ctx, resourceUuid, payload)
-
-	if err != nil {
-		log.Errorf("Error calling instance termination API: %v", err)
+	if err := d.runOperation(terminateOperation("instance removal", extra)); err != nil {
 		return err
 	}
-
-	if resp.StatusCode() != 200 {
-		responseBody := string(resp.Body[:])
-		log.Errorf("Unable to force remove the instance %s (%s), code %d, details: %s", d.GetMachineName(), d.ResourceUuid, resp.StatusCode(), responseBody)
-		msg := fmt.Sprintf("Unable to force remove the instance %s (%s), code %d", d.GetMachineName(), d.ResourceUuid, resp.StatusCode())
-		return errors.New(msg)
-	}
-
-	log.Infof("Successfully force removed the instance %s", d.GetMachineName())
+	d.cleanupAfterRemoval()
 	return nil
 }
 
-// Remove removes the host
-func (d *Driver) Remove() error {
-	log.Infof("Removing instance %s", d.GetMachineName())
-	client, err := d.getWaldurClient()
-	if err != nil {
-		log.Errorf("Error creating Waldur client %s", err)
-		return err
+// cleanupAfterRemoval releases the tenant objects managed for the machine once its
+// instance is gone. Failures are only logged as the machine itself was removed.
+func (d *Driver) cleanupAfterRemoval() {
+	if !d.ServerGroupAuto && !d.SecurityGroupAuto {
+		return
 	}
 
-	ctx := context.Background()
-	resourceUuid, err := uuid.Parse(d.ResourceUuid)
+	client, err := d.getWaldurClient()
 	if err != nil {
-		log.Errorf("Error converting resource UUID string to UUID object: %s", err)
-		return err
+		log.Warnf("Skipping cleanup for %s: %v", d.GetMachineName(), err)
+		return
 	}
-	// TODO: stop instance prior to removal?
-	var attributes interface{} = map[string]bool{
-		"delete_volumes":       true,
-		"release_floating_ips": true,
+	if err := d.waitForTermination(client); err != nil {
+		log.Warnf("Skipping cleanup for %s: %v", d.GetMachineName(), err)
+		return
 	}
-	payload := waldurclient.MarketplaceResourcesTerminateJSONRequestBody{
-		Attributes: &attributes,
+	if err := d.cleanupServerGroup(client); err != nil {
+		log.Warnf("Unable to clean up server group %s: %v", d.ServerGroupUuid, err)
 	}
-	resp, err := client.MarketplaceResourcesTerminateWithResponse(ctx, resourceUuid, payload)
-
-	if err != nil {
-		log.Errorf("Error calling instance removal API: %v", err)
-		return err
-	}
-
-	if resp.StatusCode() != 200 {
-		responseBody := string(resp.Body[:])
-		log.Errorf("Unable to remove the instance %s (%s), code %d, details: %s", d.GetMachineName(), d.ResourceUuid, resp.StatusCode(), responseBody)
-		msg := fmt.Sprintf("Unable to remove the instance %s (%s), code %d", d.GetMachineName(), d.ResourceUuid, resp.StatusCode())
-		return errors.New(msg)
+	if err := d.cleanupSecurityGroup(client); err != nil {
+		log.Warnf("Unable to clean up security group %s: %v", d.SecurityGroupAutoUuid, err)
 	}
-
-	log.Infof("Successfully removed the instance %s", d.GetMachineName())
-	return nil
 }
 
 func (d *Driver) GetSSHHostname() (string, error) {
@@ -0,0 +1,443 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/state"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// fastPolling shortens the polling intervals and timeouts for the duration of the test
+func fastPolling(t *testing.T) {
+	t.Helper()
+	interval, timeout := creationPollInterval, creationPollTimeout
+	opInterval, opTimeout := operationPollInterval, operationPollTimeout
+	creationPollInterval, creationPollTimeout = time.Millisecond, time.Second
+	operationPollInterval, operationPollTimeout = time.Millisecond, time.Second
+	t.Cleanup(func() {
+		creationPollInterval, creationPollTimeout = interval, timeout
+		operationPollInterval, operationPollTimeout = opInterval, opTimeout
+	})
+}
+
+// newTestDriver returns a driver configured against the fake Waldur API
+func newTestDriver(t *testing.T, f *fakeWaldur) *Driver {
+	t.Helper()
+	fastPolling(t)
+	storePath := t.TempDir()
+	d := NewDriver("node-1", storePath)
+	if err := os.MkdirAll(filepath.Join(storePath, "machines", "node-1"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	d.ApiUrl = f.URL()
+	d.ApiToken = "token"
+	d.ProjectUuid = uuid.NewString()
+	d.OfferingUuid = uuid.NewString()
+	d.FlavorUuid = uuid.NewString()
+	d.ImageUuid = uuid.NewString()
+	d.SystemVolumeSize = 20
+	d.SystemVolumeTypeUuid = uuid.NewString()
+	d.DataVolumeTypeUuid = uuid.NewString()
+	d.SecurityGroupUuid = uuid.NewString()
+	d.SubnetUuids = []string{uuid.NewString()}
+	d.SSHUser = "ubuntu"
+	return d
+}
+
+type recordedOperation struct {
+	name string
+	err  error
+}
+
+type recordingMetrics struct {
+	operations []recordedOperation
+}
+
+func (m *recordingMetrics) ObserveOperation(name string, duration time.Duration, err error) {
+	m.operations = append(m.operations, recordedOperation{name: name, err: err})
+}
+
+func TestCreate(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if d.IPAddress != "192.168.42.10" {
+		t.Errorf("unexpected IP address %q", d.IPAddress)
+	}
+	if f.Resource(d.ResourceUuid) == nil {
+		t.Fatalf("resource %s was not created", d.ResourceUuid)
+	}
+	if len(f.Orders) != 1 {
+		t.Fatalf("expected a single order, got %d", len(f.Orders))
+	}
+	order := f.Orders[0]
+	if order["project"] != d.ApiUrl+"/api/projects/"+d.ProjectUuid+"/" {
+		t.Errorf("unexpected project %v", order["project"])
+	}
+	attributes := order["attributes"].(map[string]any)
+	if attributes["name"] != "node-1" {
+		t.Errorf("unexpected name %v", attributes["name"])
+	}
+	if attributes["system_volume_size"] != float64(20*1024) {
+		t.Errorf("unexpected system volume size %v", attributes["system_volume_size"])
+	}
+	publicKey, err := os.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userData, _ := attributes["user_data"].(string)
+	if !strings.Contains(userData, strings.TrimSpace(string(publicKey))) {
+		t.Errorf("user data does not contain the SSH public key: %q", userData)
+	}
+}
+
+func TestCreateOrderRejected(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	f.Override("POST /api/marketplace-orders/", 400, map[string]any{
+		"flavor": []string{"Invalid hyperlink - Object does not exist."},
+	})
+
+	err := d.Create()
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.FieldErrors["flavor"] == nil {
+		t.Fatalf("expected flavor field error, got %v", err)
+	}
+}
+
+func TestCreateQuotaExceeded(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	f.Override("POST /api/marketplace-orders/", 400, []string{
+		"One or more quotas were exceeded: vcpu quota limit: 20, requires 24 (Tenant: dev)",
+	})
+
+	err := d.Create()
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) || len(quotaErr.Shortages) != 1 || quotaErr.Shortages[0].Missing() != 4 {
+		t.Fatalf("unexpected quota error %v", err)
+	}
+}
+
+func TestWaitForActiveErred(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	resource := f.AddResource("node-1", "BUILDING")
+	resource.State = "Erred"
+	resource.ErrorMessage = "No valid host was found."
+	d.ResourceUuid = resource.Uuid
+
+	client, err := d.getWaldurClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.waitForActive(client)
+	if err == nil || !strings.Contains(err.Error(), "No valid host was found.") {
+		t.Fatalf("expected error state failure, got %v", err)
+	}
+}
+
+func TestWaitForActiveTimeout(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	creationPollTimeout = 20 * time.Millisecond
+	resource := f.AddResource("node-1", "BUILDING")
+	d.ResourceUuid = resource.Uuid
+
+	client, err := d.getWaldurClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.waitForActive(client)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestGetState(t *testing.T) {
+	tests := []struct {
+		runtimeState string
+		expected     state.State
+	}{
+		{"ACTIVE", state.Running},
+		{"BUILDING", state.Starting},
+		{"SHUTOFF", state.Stopped},
+		{"ERROR", state.Error},
+		{"PAUSED", state.Paused},
+		{"SHELVED", state.Stopped},
+		{"SHELVED_OFFLOADED", state.Stopped},
+		{"", state.None},
+	}
+	for _, tt := range tests {
+		t.Run(tt.runtimeState, func(t *testing.T) {
+			f := newFakeWaldur(t)
+			d := newTestDriver(t, f)
+			d.ResourceUuid = f.AddResource("node-1", tt.runtimeState).Uuid
+
+			s, err := d.GetState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, s)
+			}
+		})
+	}
+}
+
+func TestGetStateMissingResource(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.ResourceUuid = uuid.NewString()
+
+	if _, err := d.GetState(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestInstanceActions(t *testing.T) {
+	tests := []struct {
+		action   string
+		initial  string
+		run      func(d *Driver) error
+		expected string
+	}{
+		{"start", "SHUTOFF", (*Driver).Start, "ACTIVE"},
+		{"stop", "ACTIVE", (*Driver).Stop, "SHUTOFF"},
+		// Restart does not wait, so the following GetState observes the reboot
+		{"restart", "ACTIVE", (*Driver).Restart, "REBOOT"},
+		{"shelve", "ACTIVE", func(d *Driver) error { d.StopMode = stopModeShelve; return d.Stop() }, "SHELVED_OFFLOADED"},
+		{"unshelve", "SHELVED_OFFLOADED", (*Driver).Start, "ACTIVE"},
+		{"suspend", "ACTIVE", func(d *Driver) error { d.StopMode = stopModeSuspend; return d.Stop() }, "SUSPENDED"},
+		{"resume", "SUSPENDED", (*Driver).Start, "ACTIVE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			f := newFakeWaldur(t)
+			d := newTestDriver(t, f)
+			metrics := &recordingMetrics{}
+			d.Metrics = metrics
+			resource := f.AddResource("node-1", tt.initial)
+			d.ResourceUuid = resource.Uuid
+
+			if err := tt.run(d); err != nil {
+				t.Fatal(err)
+			}
+			if len(f.Actions) != 1 || f.Actions[0] != tt.action {
+				t.Errorf("unexpected actions %v", f.Actions)
+			}
+			if len(metrics.operations) != 1 || metrics.operations[0].err != nil {
+				t.Errorf("unexpected metrics %v", metrics.operations)
+			}
+			if _, err := d.GetState(); err != nil {
+				t.Fatal(err)
+			}
+			if resource.RuntimeState != tt.expected {
+				t.Errorf("expected runtime state %s, got %s", tt.expected, resource.RuntimeState)
+			}
+		})
+	}
+}
+
+func TestStopModesReportStopped(t *testing.T) {
+	for _, mode := range []string{"", stopModeStop, stopModeShelve, stopModeSuspend} {
+		t.Run(mode, func(t *testing.T) {
+			f := newFakeWaldur(t)
+			d := newTestDriver(t, f)
+			d.StopMode = mode
+			d.ResourceUuid = f.AddResource("node-1", "ACTIVE").Uuid
+
+			if err := d.Stop(); err != nil {
+				t.Fatal(err)
+			}
+			if st, err := d.GetState(); err != nil || st != state.Stopped {
+				t.Errorf("expected the stopped state, got %v, %v", st, err)
+			}
+		})
+	}
+}
+
+func TestInstanceActionConflict(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	metrics := &recordingMetrics{}
+	d.Metrics = metrics
+	d.ResourceUuid = f.AddResource("node-1", "ACTIVE").Uuid
+	f.Override("POST /api/openstack-instances/{uuid}/{action}/", 409, map[string]string{
+		"detail": "Instance is already running.",
+	})
+
+	err := d.Start()
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if len(metrics.operations) != 1 || metrics.operations[0].err == nil {
+		t.Errorf("expected failed operation in metrics, got %v", metrics.operations)
+	}
+}
+
+// countingClient wraps the default client, as caching or metrics layers would
+type countingClient struct {
+	WaldurAPI
+	resourceCalls int
+}
+
+func (c *countingClient) MarketplaceResourcesRetrieveWithResponse(ctx context.Context, id uuid.UUID, params *waldurclient.MarketplaceResourcesRetrieveParams, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.MarketplaceResourcesRetrieveResponse, error) {
+	c.resourceCalls++
+	return c.WaldurAPI.MarketplaceResourcesRetrieveWithResponse(ctx, id, params, reqEditors...)
+}
+
+// unreachableClient fails every instance start as if Waldur was unreachable
+type unreachableClient struct {
+	WaldurAPI
+}
+
+func (c *unreachableClient) OpenstackInstancesStartWithResponse(ctx context.Context, id uuid.UUID, reqEditors ...waldurclient.RequestEditorFn) (*waldurclient.OpenstackInstancesStartResponse, error) {
+	return nil, errUnreachable
+}
+
+var errUnreachable = errors.New("connection refused")
+
+func TestInjectedClient(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.ResourceUuid = f.AddResource("node-1", "ACTIVE").Uuid
+	defaultClient, err := newWaldurClient(d.ApiUrl, d.ApiToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counting := &countingClient{WaldurAPI: defaultClient}
+	d.Client = counting
+	if _, err := d.GetState(); err != nil {
+		t.Fatal(err)
+	}
+	if counting.resourceCalls != 1 {
+		t.Errorf("expected a single resource call, got %d", counting.resourceCalls)
+	}
+
+	d.Client = &unreachableClient{WaldurAPI: defaultClient}
+	if err := d.Start(); !errors.Is(err, errUnreachable) {
+		t.Fatalf("expected transport error, got %v", err)
+	}
+}
+
+func TestKill(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.ResourceUuid = f.AddResource("node-1", "ACTIVE").Uuid
+
+	if err := d.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Terminations) != 1 || f.Terminations[0]["action"] != "force_destroy" {
+		t.Fatalf("unexpected terminations %v", f.Terminations)
+	}
+	if f.Terminations[0]["delete_volumes"] != true {
+		t.Errorf("volumes are not deleted: %v", f.Terminations[0])
+	}
+}
+
+func TestRemove(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.ResourceUuid = f.AddResource("node-1", "ACTIVE").Uuid
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Terminations) != 1 || f.Terminations[0]["action"] != nil {
+		t.Fatalf("unexpected terminations %v", f.Terminations)
+	}
+	if !f.Resource(d.ResourceUuid).Terminated {
+		t.Error("resource was not terminated")
+	}
+}
+
+func TestRemoveMissingResource(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.ResourceUuid = uuid.NewString()
+
+	if err := d.Remove(); err != nil {
+		t.Fatalf("removing a missing resource should succeed, got %v", err)
+	}
+}
+
+func TestRemoveForbidden(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.ResourceUuid = f.AddResource("node-1", "ACTIVE").Uuid
+	f.Override("POST /api/marketplace-resources/{uuid}/terminate/", 403, map[string]string{
+		"detail": "You do not have permission to perform this action.",
+	})
+
+	if err := d.Remove(); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+}
+
+func TestBuildUserData(t *testing.T) {
+	tests := []struct {
+		name      string
+		userData  string
+		publicKey string
+		expected  string
+	}{
+		{
+			name:     "no key",
+			userData: "#!/bin/sh\necho hi\n",
+			expected: "#!/bin/sh\necho hi\n",
+		},
+		{
+			name:      "key only",
+			publicKey: "ssh-rsa AAAA test\n",
+			expected:  "#cloud-config\nusers:\n  - name: ubuntu\n    sudo: ALL=(ALL) NOPASSWD:ALL\n    ssh_authorized_keys:\n      - ssh-rsa AAAA test\n",
+		},
+		{
+			name:      "key and user data",
+			userData:  "#cloud-config\npackages: [curl]\n",
+			publicKey: "ssh-rsa AAAA test",
+			expected:  "#cloud-config\nusers:\n  - name: ubuntu\n    sudo: ALL=(ALL) NOPASSWD:ALL\n    ssh_authorized_keys:\n      - ssh-rsa AAAA test\n---\n#cloud-config\npackages: [curl]\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDriver("node-1", t.TempDir())
+			d.SSHUser = "ubuntu"
+			d.UserData = tt.userData
+
+			if got := d.buildUserData([]byte(tt.publicKey)); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateStopMode(t *testing.T) {
+	for _, mode := range []string{"", stopModeStop, stopModeShelve, stopModeSuspend} {
+		if err := validateStopMode(mode); err != nil {
+			t.Errorf("%q: unexpected error %v", mode, err)
+		}
+	}
+	if err := validateStopMode("hibernate"); err == nil || !strings.Contains(err.Error(), "invalid stop mode") {
+		t.Errorf("expected invalid stop mode error, got %v", err)
+	}
+}
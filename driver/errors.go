@@ -0,0 +1,163 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Sentinel errors classifying failed Waldur API calls, to be matched with errors.Is
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrValidation       = errors.New("validation failed")
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+	ErrConflict         = errors.New("conflict")
+)
+
+// APIError describes a Waldur API call which returned an unexpected status code
+type APIError struct {
+	// Operation is a short description of the failed call, e.g. "instance start"
+	Operation string
+	// ResourceUuid is the UUID of the marketplace resource involved, if known
+	ResourceUuid string
+	StatusCode   int
+	// Details holds the messages Waldur returned in the response body
+	Details []string
+	// FieldErrors holds validation messages keyed by request field
+	FieldErrors map[string][]string
+	// Body is the raw response body
+	Body string
+}
+
+// newAPIError builds an APIError, parsing Waldur error details from the JSON body
+func newAPIError(operation, resourceUuid string, statusCode int, body []byte) *APIError {
+	e := &APIError{
+		Operation:    operation,
+		ResourceUuid: resourceUuid,
+		StatusCode:   statusCode,
+		Body:         string(body),
+		FieldErrors:  map[string][]string{},
+	}
+	e.parseBody(body)
+	return e
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("waldur %s failed", e.Operation)
+	if e.ResourceUuid != "" {
+		msg += fmt.Sprintf(" for resource %s", e.ResourceUuid)
+	}
+	msg += fmt.Sprintf(", code %d", e.StatusCode)
+	if messages := e.Messages(); len(messages) > 0 {
+		msg += ": " + strings.Join(messages, "; ")
+	}
+	return msg
+}
+
+// Messages returns all error messages, field errors prefixed with the field name
+func (e *APIError) Messages() []string {
+	messages := append([]string{}, e.Details...)
+	fields := make([]string, 0, len(e.FieldErrors))
+	for field := range e.FieldErrors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		for _, message := range e.FieldErrors[field] {
+			messages = append(messages, fmt.Sprintf("%s: %s", field, message))
+		}
+	}
+	return messages
+}
+
+// Is reports whether the error matches one of the sentinel errors of this package
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrPermissionDenied:
+		return e.StatusCode == http.StatusForbidden
+	case ErrValidation:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrQuotaExceeded:
+		return e.isQuotaError()
+	}
+	return false
+}
+
+func (e *APIError) isQuotaError() bool {
+	if e.StatusCode != http.StatusBadRequest && e.StatusCode != http.StatusConflict {
+		return false
+	}
+	for _, message := range e.Messages() {
+		if strings.Contains(strings.ToLower(message), "quota") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBody extracts messages from Django REST framework style error bodies:
+// {"detail": "..."}, {"field": ["..."]}, ["..."] or plain text
+func (e *APIError) parseBody(body []byte) {
+	var payload any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		if text := strings.TrimSpace(string(body)); text != "" && !strings.HasPrefix(text, "<") {
+			e.Details = append(e.Details, text)
+		}
+		return
+	}
+
+	switch value := payload.(type) {
+	case map[string]any:
+		for key, item := range value {
+			messages := flattenMessages(item)
+			if key == "detail" || key == "non_field_errors" {
+				e.Details = append(e.Details, messages...)
+			} else if len(messages) > 0 {
+				e.FieldErrors[key] = messages
+			}
+		}
+	default:
+		e.Details = append(e.Details, flattenMessages(value)...)
+	}
+}
+
+func flattenMessages(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		messages := []string{}
+		for _, item := range v {
+			messages = append(messages, flattenMessages(item)...)
+		}
+		return messages
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		messages := []string{}
+		for _, key := range keys {
+			for _, message := range flattenMessages(v[key]) {
+				messages = append(messages, fmt.Sprintf("%s: %s", key, message))
+			}
+		}
+		return messages
+	case nil:
+		return nil
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
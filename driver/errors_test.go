@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestAPIErrorParsing(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{"detail", `{"detail": "Not found."}`, []string{"Not found."}},
+		{"list", `["first", "second"]`, []string{"first", "second"}},
+		{"fields", `{"name": ["This field is required."], "non_field_errors": ["Invalid."]}`, []string{"Invalid.", "name: This field is required."}},
+		{"nested", `{"ports": [{"subnet": ["Invalid hyperlink."]}]}`, []string{"ports: subnet: Invalid hyperlink."}},
+		{"text", "Bad Gateway", []string{"Bad Gateway"}},
+		{"html", "<html>error</html>", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := newAPIError("test", "", 400, []byte(tt.body)).Messages()
+			if fmt.Sprint(messages) != fmt.Sprint(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, messages)
+			}
+		})
+	}
+}
+
+func TestAPIErrorSentinels(t *testing.T) {
+	tests := []struct {
+		status   int
+		body     string
+		sentinel error
+	}{
+		{404, `{"detail": "Not found."}`, ErrNotFound},
+		{401, `{"detail": "Invalid token."}`, ErrUnauthorized},
+		{403, `{"detail": "Forbidden."}`, ErrPermissionDenied},
+		{400, `{"name": ["Required."]}`, ErrValidation},
+		{409, `{"detail": "Conflict."}`, ErrConflict},
+		{400, `["One or more quotas were exceeded: ram quota limit: 1024, requires 2048"]`, ErrQuotaExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sentinel.Error(), func(t *testing.T) {
+			var err error = newAPIError("test", "res", tt.status, []byte(tt.body))
+			err = fmt.Errorf("wrapped: %w", err)
+			if !errors.Is(err, tt.sentinel) {
+				t.Errorf("expected %v to match %v", err, tt.sentinel)
+			}
+		})
+	}
+
+	err := newAPIError("test", "", 400, []byte(`{"name": ["Required."]}`))
+	if errors.Is(err, ErrQuotaExceeded) {
+		t.Error("validation error should not match quota sentinel")
+	}
+}
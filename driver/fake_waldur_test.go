@@ -0,0 +1,898 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeResource models a marketplace resource backed by an OpenStack instance
+type fakeResource struct {
+	Uuid         string
+	InstanceUuid string
+	ProjectUuid  string
+	Name         string
+	Description  string
+	Attributes   map[string]any
+	Created      time.Time
+	State        string
+	ErrorMessage string
+	RuntimeState string
+	// Pending runtime states are applied one per retrieval, simulating state transitions
+	Pending        []string
+	InternalIps    []string
+	Terminated     bool
+	ServerGroup    string
+	SecurityGroups []string
+	FlavorName     string
+	// NextFlavor is applied to the instance after FlavorPolls more retrievals, the instance
+	// is Updating meanwhile, or Erred with FlavorError
+	NextFlavor  string
+	FlavorPolls int
+	FlavorError string
+	Volumes     []*fakeVolume
+}
+
+// fakeVolume models an OpenStack volume attached to an instance
+type fakeVolume struct {
+	Uuid      string
+	BackendId string
+	Bootable  bool
+	Size      int
+	Device    string
+	State     string
+}
+
+// fakeSnapshot models an OpenStack volume snapshot or instance backup
+type fakeSnapshot struct {
+	Uuid   string
+	Name   string
+	Source string
+	State  string
+}
+
+// fakeServerGroup models an OpenStack server group of a tenant
+type fakeServerGroup struct {
+	Uuid       string
+	Name       string
+	Policy     string
+	TenantUuid string
+	Created    time.Time
+	Deleted    bool
+}
+
+// fakeSecurityGroup models an OpenStack security group of a tenant
+type fakeSecurityGroup struct {
+	Uuid       string
+	Name       string
+	TenantUuid string
+	Rules      []map[string]any
+	// States lists the states reported by retrievals after the creation, the last one persisting
+	States  []string
+	Deleted bool
+}
+
+// fakeResponse is a canned reply overriding the regular handler of a route
+type fakeResponse struct {
+	Status int
+	Body   any
+}
+
+// fakeWaldur is an in-process fake of the Waldur API endpoints used by the driver
+type fakeWaldur struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	resources map[string]*fakeResource
+	instances map[string]*fakeResource
+	// Orders holds the payloads of the submitted orders
+	Orders []map[string]any
+	// Terminations holds the attributes of terminate requests
+	Terminations []map[string]any
+	// Actions records instance actions, e.g. "start"
+	Actions []string
+	// Overrides maps "METHOD path" patterns to canned replies, consumed on use
+	Overrides map[string][]fakeResponse
+	// Objects maps "kind/uuid" to JSON documents served by generic retrieval routes
+	Objects map[string]any
+	// Lists maps kinds to the items served by generic list routes, filtered by query parameters
+	Lists map[string][]map[string]any
+	// ActionTransitions lists the runtime states an instance passes through after an action
+	ActionTransitions map[string][]string
+	// CreateTransitions lists the runtime states a new instance passes through
+	CreateTransitions []string
+	ServerGroups      map[string]*fakeServerGroup
+	// BeforeServerGroupCreate runs before a server group is created, e.g. to simulate a concurrent creation
+	BeforeServerGroupCreate func(tenantUuid, name string)
+	SecurityGroups          map[string]*fakeSecurityGroup
+	// BeforeSecurityGroupCreate runs before a security group is created, e.g. to simulate a concurrent creation
+	BeforeSecurityGroupCreate func(tenantUuid, name string)
+	// SecurityGroupStates lists the states a new security group passes through before OK
+	SecurityGroupStates []string
+	volumes             map[string]*fakeVolume
+	// Snapshots maps UUIDs to the created volume snapshots and instance backups
+	Snapshots map[string]*fakeSnapshot
+	// Restorations holds the payloads of backup restorations
+	Restorations []map[string]any
+}
+
+func newFakeWaldur(t *testing.T) *fakeWaldur {
+	f := &fakeWaldur{
+		t:              t,
+		resources:      map[string]*fakeResource{},
+		instances:      map[string]*fakeResource{},
+		Overrides:      map[string][]fakeResponse{},
+		Objects:        map[string]any{},
+		Lists:          map[string][]map[string]any{},
+		ServerGroups:   map[string]*fakeServerGroup{},
+		SecurityGroups: map[string]*fakeSecurityGroup{},
+		volumes:        map[string]*fakeVolume{},
+		Snapshots:      map[string]*fakeSnapshot{},
+		ActionTransitions: map[string][]string{
+			"start":    {"ACTIVE"},
+			"stop":     {"SHUTOFF"},
+			"restart":  {"REBOOT", "ACTIVE"},
+			"shelve":   {"SHELVING", "SHELVED_OFFLOADED"},
+			"unshelve": {"UNSHELVING", "ACTIVE"},
+			"suspend":  {"SUSPENDED"},
+			"resume":   {"ACTIVE"},
+			// the resource is updating until the resize completes
+			"change_flavor": {"RESIZE", "SHUTOFF"},
+		},
+		CreateTransitions: []string{"BUILDING", "ACTIVE"},
+	}
+
+	mux := http.NewServeMux()
+	f.handle(mux, "POST /api/marketplace-orders/", f.createOrder)
+	f.handle(mux, "GET /api/marketplace-resources/{uuid}/", f.getResource)
+	f.handle(mux, "PATCH /api/marketplace-resources/{uuid}/", f.updateResource)
+	f.handle(mux, "POST /api/marketplace-resources/{uuid}/terminate/", f.terminateResource)
+	f.handle(mux, "GET /api/openstack-instances/", f.listInstances)
+	f.handle(mux, "GET /api/openstack-instances/{uuid}/", f.getInstance)
+	f.handle(mux, "POST /api/openstack-instances/{uuid}/{action}/", f.instanceAction)
+	for _, kind := range []string{"marketplace-public-offerings", "openstack-tenants", "openstack-flavors", "openstack-subnets", "projects"} {
+		f.handle(mux, "GET /api/"+kind+"/{uuid}/", f.getObject(kind))
+	}
+	for _, kind := range []string{"openstack-instance-availability-zones", "openstack-networks", "openstack-subnets",
+		"projects", "marketplace-public-offerings", "openstack-flavors", "openstack-images", "openstack-volume-types"} {
+		f.handle(mux, "GET /api/"+kind+"/", f.listObjects(kind))
+	}
+	f.handle(mux, "GET /api/openstack-server-groups/", f.listServerGroups)
+	f.handle(mux, "GET /api/openstack-server-groups/{uuid}/", f.getServerGroup)
+	f.handle(mux, "DELETE /api/openstack-server-groups/{uuid}/", f.deleteServerGroup)
+	f.handle(mux, "POST /api/openstack-tenants/{uuid}/create_server_group/", f.createServerGroup)
+	f.handle(mux, "GET /api/openstack-volumes/{uuid}/", f.getVolume)
+	f.handle(mux, "POST /api/openstack-volumes/{uuid}/extend/", f.extendVolume)
+	f.handle(mux, "POST /api/openstack-volumes/{uuid}/snapshot/", f.createSnapshot("snapshot", f.volumeExists))
+	f.handle(mux, "GET /api/openstack-snapshots/{uuid}/", f.getSnapshot)
+	f.handle(mux, "POST /api/openstack-instances/{uuid}/backup/", f.createSnapshot("backup", f.instanceExists))
+	f.handle(mux, "GET /api/openstack-backups/{uuid}/", f.getSnapshot)
+	f.handle(mux, "POST /api/openstack-backups/{uuid}/restore/", f.restoreBackup)
+	f.handle(mux, "GET /api/marketplace-resources/", f.listResources)
+	f.handle(mux, "GET /api/openstack-security-groups/", f.listSecurityGroups)
+	f.handle(mux, "GET /api/openstack-security-groups/{uuid}/", f.getSecurityGroup)
+	f.handle(mux, "DELETE /api/openstack-security-groups/{uuid}/", f.deleteSecurityGroup)
+	f.handle(mux, "POST /api/openstack-tenants/{uuid}/create_security_group/", f.createSecurityGroup)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// URL returns the base URL of the fake API
+func (f *fakeWaldur) URL() string {
+	return f.server.URL
+}
+
+// handle registers a handler which first serves pending overrides for the pattern
+func (f *fakeWaldur) handle(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		overrides := f.Overrides[pattern]
+		if len(overrides) > 0 {
+			f.Overrides[pattern] = overrides[1:]
+			f.mu.Unlock()
+			writeJSON(w, overrides[0].Status, overrides[0].Body)
+			return
+		}
+		f.mu.Unlock()
+		handler(w, r)
+	})
+}
+
+// Override queues a canned reply for the next request matching the pattern
+func (f *fakeWaldur) Override(pattern string, status int, body any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Overrides[pattern] = append(f.Overrides[pattern], fakeResponse{Status: status, Body: body})
+}
+
+// AddResource registers an existing resource and returns it
+func (f *fakeWaldur) AddResource(name, runtimeState string) *fakeResource {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource := &fakeResource{
+		Uuid:         uuid.NewString(),
+		InstanceUuid: uuid.NewString(),
+		Name:         name,
+		Created:      time.Now().UTC(),
+		State:        "OK",
+		RuntimeState: runtimeState,
+		InternalIps:  []string{"192.168.42.10"},
+	}
+	f.resources[resource.Uuid] = resource
+	f.instances[resource.InstanceUuid] = resource
+	return resource
+}
+
+// Resource returns the resource with the given marketplace UUID
+func (f *fakeWaldur) Resource(id string) *fakeResource {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.resources[id]
+}
+
+// AddListItem appends an item served by the generic list route of the kind
+func (f *fakeWaldur) AddListItem(kind string, item map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Lists[kind] = append(f.Lists[kind], item)
+}
+
+// SetObject stores a JSON document served by the generic retrieval route of the kind
+func (f *fakeWaldur) SetObject(kind, id string, object any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Objects[kind+"/"+id] = object
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+func notFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Not found."})
+}
+
+func (f *fakeWaldur) createOrder(w http.ResponseWriter, r *http.Request) {
+	payload := map[string]any{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+	attributes, _ := payload["attributes"].(map[string]any)
+	name, _ := attributes["name"].(string)
+
+	resource := f.AddResource(name, "")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource.State = "Creating"
+	resource.Pending = append([]string{}, f.CreateTransitions...)
+	resource.Attributes = attributes
+	resource.Description, _ = attributes["description"].(string)
+	if project, ok := payload["project"].(string); ok {
+		resource.ProjectUuid = path.Base(strings.TrimSuffix(project, "/"))
+	}
+	if serverGroup, ok := attributes["server_group"].(string); ok {
+		resource.ServerGroup = serverGroup
+	}
+	securityGroups, _ := attributes["security_groups"].([]any)
+	for _, group := range securityGroups {
+		if url, ok := group.(map[string]any)["url"].(string); ok {
+			resource.SecurityGroups = append(resource.SecurityGroups, url)
+		}
+	}
+	f.Orders = append(f.Orders, payload)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"uuid":          uuid.NewString(),
+		"state":         "executing",
+		"resource_uuid": resource.Uuid,
+	})
+}
+
+func (f *fakeWaldur) getResource(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource, ok := f.resources[r.PathValue("uuid")]
+	if !ok || resource.Terminated {
+		notFound(w)
+		return
+	}
+	if len(resource.Pending) > 0 {
+		resource.RuntimeState = resource.Pending[0]
+		resource.Pending = resource.Pending[1:]
+		if resource.RuntimeState == "ACTIVE" && resource.State == "Creating" {
+			resource.State = "OK"
+		}
+		if len(resource.Pending) == 0 && resource.State == "Updating" {
+			resource.State = "OK"
+		}
+	}
+	body := map[string]any{
+		"uuid":          resource.Uuid,
+		"name":          resource.Name,
+		"state":         resource.State,
+		"resource_uuid": resource.InstanceUuid,
+		"resource_type": "OpenStack.Instance",
+		"backend_metadata": map[string]any{
+			"runtime_state": resource.RuntimeState,
+		},
+	}
+	if resource.ErrorMessage != "" {
+		body["error_message"] = resource.ErrorMessage
+	}
+	if resource.ProjectUuid != "" {
+		body["project_uuid"] = resource.ProjectUuid
+	}
+	if resource.Description != "" {
+		body["description"] = resource.Description
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (f *fakeWaldur) updateResource(w http.ResponseWriter, r *http.Request) {
+	payload := struct {
+		Description *string `json:"description"`
+	}{}
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource, ok := f.resources[r.PathValue("uuid")]
+	if !ok || resource.Terminated {
+		notFound(w)
+		return
+	}
+	if payload.Description != nil {
+		resource.Description = *payload.Description
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"uuid": resource.Uuid, "description": resource.Description})
+}
+
+func (f *fakeWaldur) terminateResource(w http.ResponseWriter, r *http.Request) {
+	payload := map[string]any{}
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource, ok := f.resources[r.PathValue("uuid")]
+	if !ok || resource.Terminated {
+		notFound(w)
+		return
+	}
+	attributes, _ := payload["attributes"].(map[string]any)
+	f.Terminations = append(f.Terminations, attributes)
+	resource.Terminated = true
+	writeJSON(w, http.StatusOK, map[string]any{"order_uuid": uuid.NewString()})
+}
+
+func (f *fakeWaldur) getInstance(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource, ok := f.instances[r.PathValue("uuid")]
+	if !ok || resource.Terminated {
+		notFound(w)
+		return
+	}
+	state := "OK"
+	if resource.NextFlavor != "" {
+		switch {
+		case resource.FlavorError != "":
+			state = "Erred"
+		case resource.FlavorPolls > 0:
+			state = "Updating"
+			resource.FlavorPolls--
+		default:
+			resource.FlavorName = resource.NextFlavor
+			resource.NextFlavor = ""
+		}
+	}
+	body := map[string]any{
+		"uuid":          resource.InstanceUuid,
+		"name":          resource.Name,
+		"state":         state,
+		"runtime_state": resource.RuntimeState,
+		"internal_ips":  resource.InternalIps,
+	}
+	if state == "Erred" {
+		body["error_message"] = resource.FlavorError
+	}
+	if resource.FlavorName != "" {
+		body["flavor_name"] = resource.FlavorName
+	}
+	volumes := []map[string]any{}
+	for _, volume := range resource.Volumes {
+		volumes = append(volumes, map[string]any{
+			"uuid":     volume.Uuid,
+			"bootable": volume.Bootable,
+			"size":     volume.Size,
+			"device":   volume.Device,
+		})
+	}
+	body["volumes"] = volumes
+	writeJSON(w, http.StatusOK, body)
+}
+
+// listInstances serves the instances which are not terminated with their security groups
+func (f *fakeWaldur) listInstances(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	instances := []map[string]any{}
+	for _, resource := range f.instances {
+		if resource.Terminated {
+			continue
+		}
+		securityGroups := []map[string]any{}
+		for _, url := range resource.SecurityGroups {
+			securityGroups = append(securityGroups, map[string]any{"url": url})
+		}
+		instances = append(instances, map[string]any{
+			"uuid":            resource.InstanceUuid,
+			"name":            resource.Name,
+			"runtime_state":   resource.RuntimeState,
+			"security_groups": securityGroups,
+		})
+	}
+	sortByUuid(instances)
+	writePage(w, r, instances)
+}
+
+func (f *fakeWaldur) instanceAction(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource, ok := f.instances[r.PathValue("uuid")]
+	if !ok || resource.Terminated {
+		notFound(w)
+		return
+	}
+	action := r.PathValue("action")
+	if resource.NextFlavor != "" {
+		writeJSON(w, http.StatusConflict, map[string]string{"detail": "Instance is updating."})
+		return
+	}
+	transitions, ok := f.ActionTransitions[action]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": fmt.Sprintf("unknown action %s", action)})
+		return
+	}
+	if action == "change_flavor" {
+		payload := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		flavorUuid := path.Base(strings.TrimSuffix(payload["flavor"], "/"))
+		flavor, ok := f.Objects["openstack-flavors/"+flavorUuid].(map[string]any)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"flavor": []string{"Invalid hyperlink - Object does not exist."}})
+			return
+		}
+		// the marketplace resource stays OK, only the instance shows the change in progress
+		resource.NextFlavor, _ = flavor["name"].(string)
+		resource.FlavorPolls = 2
+	}
+	f.Actions = append(f.Actions, action)
+	resource.Pending = append([]string{}, transitions...)
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": action + " was scheduled"})
+}
+
+func (f *fakeWaldur) getObject(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		object, ok := f.Objects[kind+"/"+r.PathValue("uuid")]
+		if !ok {
+			notFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, object)
+	}
+}
+
+func (f *fakeWaldur) listObjects(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		items := []map[string]any{}
+	items:
+		for _, item := range f.Lists[kind] {
+			for key, values := range r.URL.Query() {
+				if key == "page" || key == "page_size" || key == "field" {
+					continue
+				}
+				if fmt.Sprint(item[key]) != values[0] {
+					continue items
+				}
+			}
+			items = append(items, item)
+		}
+		writePage(w, r, items)
+	}
+}
+
+// writePage writes the page of the items requested with the page and page_size parameters
+func writePage(w http.ResponseWriter, r *http.Request, items []map[string]any) {
+	page, pageSize := 1, len(items)
+	if value, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && value > 0 {
+		page = value
+	}
+	if value, err := strconv.Atoi(r.URL.Query().Get("page_size")); err == nil && value > 0 {
+		pageSize = value
+	}
+	start := min((page-1)*pageSize, len(items))
+	end := min(start+pageSize, len(items))
+	writeJSON(w, http.StatusOK, items[start:end])
+}
+
+// sortByUuid orders the items of a map-backed listing, so that its pages are stable
+func sortByUuid(items []map[string]any) {
+	sort.Slice(items, func(i, j int) bool {
+		return fmt.Sprint(items[i]["uuid"]) < fmt.Sprint(items[j]["uuid"])
+	})
+}
+
+// shortPages makes the driver request pages of a single item for the duration of the test
+func shortPages(t *testing.T) {
+	pageSize := listPageSize
+	listPageSize = 1
+	t.Cleanup(func() {
+		listPageSize = pageSize
+	})
+}
+
+// AddServerGroup registers an existing server group in the tenant
+func (f *fakeWaldur) AddServerGroup(tenantUuid, name string) *fakeServerGroup {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group := &fakeServerGroup{Uuid: uuid.NewString(), Name: name, Policy: "anti-affinity", TenantUuid: tenantUuid, Created: time.Now()}
+	f.ServerGroups[group.Uuid] = group
+	return group
+}
+
+func (f *fakeWaldur) serverGroupURL(group *fakeServerGroup) string {
+	return f.server.URL + "/api/openstack-server-groups/" + group.Uuid + "/"
+}
+
+// serverGroupJSON must be called with the lock held
+func (f *fakeWaldur) serverGroupJSON(group *fakeServerGroup) map[string]any {
+	instances := []map[string]any{}
+	for _, resource := range f.resources {
+		if !resource.Terminated && resource.ServerGroup == f.serverGroupURL(group) {
+			instances = append(instances, map[string]any{"uuid": resource.InstanceUuid, "name": resource.Name})
+		}
+	}
+	return map[string]any{
+		"uuid":      group.Uuid,
+		"url":       f.serverGroupURL(group),
+		"name":      group.Name,
+		"policy":    group.Policy,
+		"state":     "OK",
+		"created":   group.Created,
+		"instances": instances,
+	}
+}
+
+func (f *fakeWaldur) listServerGroups(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	groups := []map[string]any{}
+	for _, group := range f.ServerGroups {
+		if group.Deleted || group.TenantUuid != r.URL.Query().Get("tenant_uuid") {
+			continue
+		}
+		groups = append(groups, f.serverGroupJSON(group))
+	}
+	sortByUuid(groups)
+	writePage(w, r, groups)
+}
+
+func (f *fakeWaldur) getServerGroup(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group, ok := f.ServerGroups[r.PathValue("uuid")]
+	if !ok || group.Deleted {
+		notFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, f.serverGroupJSON(group))
+}
+
+func (f *fakeWaldur) deleteServerGroup(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group, ok := f.ServerGroups[r.PathValue("uuid")]
+	if !ok || group.Deleted {
+		notFound(w)
+		return
+	}
+	group.Deleted = true
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+func (f *fakeWaldur) createServerGroup(w http.ResponseWriter, r *http.Request) {
+	payload := map[string]any{}
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+	name, _ := payload["name"].(string)
+	if f.BeforeServerGroupCreate != nil {
+		f.BeforeServerGroupCreate(r.PathValue("uuid"), name)
+	}
+	group := f.AddServerGroup(r.PathValue("uuid"), name)
+	policy, _ := payload["policy"].(string)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group.Policy = policy
+	writeJSON(w, http.StatusCreated, f.serverGroupJSON(group))
+}
+
+// AddSecurityGroup registers an existing security group in the tenant
+func (f *fakeWaldur) AddSecurityGroup(tenantUuid, name string) *fakeSecurityGroup {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group := &fakeSecurityGroup{Uuid: uuid.NewString(), Name: name, TenantUuid: tenantUuid}
+	f.SecurityGroups[group.Uuid] = group
+	return group
+}
+
+func (group *fakeSecurityGroup) state() string {
+	if len(group.States) > 0 {
+		return group.States[0]
+	}
+	return "OK"
+}
+
+func (f *fakeWaldur) securityGroupURL(group *fakeSecurityGroup) string {
+	return f.server.URL + "/api/openstack-security-groups/" + group.Uuid + "/"
+}
+
+func (f *fakeWaldur) securityGroupJSON(group *fakeSecurityGroup) map[string]any {
+	return map[string]any{
+		"uuid":  group.Uuid,
+		"url":   f.securityGroupURL(group),
+		"name":  group.Name,
+		"state": group.state(),
+		"rules": group.Rules,
+	}
+}
+
+func (f *fakeWaldur) listSecurityGroups(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := r.URL.Query()
+	groups := []map[string]any{}
+	for _, group := range f.SecurityGroups {
+		if group.Deleted || group.TenantUuid != query.Get("tenant_uuid") {
+			continue
+		}
+		if query.Has("name") && group.Name != query.Get("name") {
+			continue
+		}
+		groups = append(groups, f.securityGroupJSON(group))
+	}
+	sortByUuid(groups)
+	writePage(w, r, groups)
+}
+
+func (f *fakeWaldur) getSecurityGroup(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group, ok := f.SecurityGroups[r.PathValue("uuid")]
+	if !ok || group.Deleted {
+		notFound(w)
+		return
+	}
+	if len(group.States) > 0 {
+		group.States = group.States[1:]
+	}
+	writeJSON(w, http.StatusOK, f.securityGroupJSON(group))
+}
+
+func (f *fakeWaldur) deleteSecurityGroup(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group, ok := f.SecurityGroups[r.PathValue("uuid")]
+	if !ok || group.Deleted {
+		notFound(w)
+		return
+	}
+	group.Deleted = true
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+func (f *fakeWaldur) createSecurityGroup(w http.ResponseWriter, r *http.Request) {
+	payload := struct {
+		Name  string           `json:"name"`
+		Rules []map[string]any `json:"rules"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+	if f.BeforeSecurityGroupCreate != nil {
+		f.BeforeSecurityGroupCreate(r.PathValue("uuid"), payload.Name)
+	}
+	group := f.AddSecurityGroup(r.PathValue("uuid"), payload.Name)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group.Rules = payload.Rules
+	group.States = append([]string{}, f.SecurityGroupStates...)
+	writeJSON(w, http.StatusCreated, f.securityGroupJSON(group))
+}
+
+// AddVolume attaches a volume of the size in MB to the resource's instance
+func (f *fakeWaldur) AddVolume(resource *fakeResource, bootable bool, size int, device string) *fakeVolume {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	volume := &fakeVolume{Uuid: uuid.NewString(), BackendId: uuid.NewString(), Bootable: bootable, Size: size, Device: device, State: "OK"}
+	resource.Volumes = append(resource.Volumes, volume)
+	f.volumes[volume.Uuid] = volume
+	return volume
+}
+
+func (f *fakeWaldur) getVolume(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	volume, ok := f.volumes[r.PathValue("uuid")]
+	if !ok {
+		notFound(w)
+		return
+	}
+	body := map[string]any{"uuid": volume.Uuid, "backend_id": volume.BackendId, "size": volume.Size, "state": volume.State}
+	// the extension completes after one retrieval
+	volume.State = "OK"
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (f *fakeWaldur) extendVolume(w http.ResponseWriter, r *http.Request) {
+	payload := struct {
+		DiskSize int `json:"disk_size"`
+	}{}
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	volume, ok := f.volumes[r.PathValue("uuid")]
+	if !ok {
+		notFound(w)
+		return
+	}
+	if payload.DiskSize <= volume.Size {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"disk_size": []string{"Disk size should be greater than the current one."}})
+		return
+	}
+	f.Actions = append(f.Actions, "extend")
+	volume.Size = payload.DiskSize
+	volume.State = "Updating"
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "extend was scheduled"})
+}
+
+func (f *fakeWaldur) volumeExists(id string) bool {
+	_, ok := f.volumes[id]
+	return ok
+}
+
+func (f *fakeWaldur) instanceExists(id string) bool {
+	resource, ok := f.instances[id]
+	return ok && !resource.Terminated
+}
+
+// createSnapshot returns a handler creating a snapshot or backup of the source in the path
+func (f *fakeWaldur) createSnapshot(action string, exists func(id string) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		payload := struct {
+			Name string `json:"name"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		source := r.PathValue("uuid")
+		if !exists(source) {
+			notFound(w)
+			return
+		}
+		snapshot := &fakeSnapshot{Uuid: uuid.NewString(), Name: payload.Name, Source: source, State: "Creating"}
+		f.Snapshots[snapshot.Uuid] = snapshot
+		f.Actions = append(f.Actions, action)
+		writeJSON(w, http.StatusCreated, map[string]any{"uuid": snapshot.Uuid, "name": snapshot.Name, "state": snapshot.State})
+	}
+}
+
+func (f *fakeWaldur) getSnapshot(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	snapshot, ok := f.Snapshots[r.PathValue("uuid")]
+	if !ok {
+		notFound(w)
+		return
+	}
+	body := map[string]any{"uuid": snapshot.Uuid, "name": snapshot.Name, "state": snapshot.State}
+	// the creation completes after one retrieval
+	if snapshot.State == "Creating" {
+		snapshot.State = "OK"
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// AddSnapshot registers an available volume snapshot or instance backup and returns it
+func (f *fakeWaldur) AddSnapshot(name string) *fakeSnapshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	snapshot := &fakeSnapshot{Uuid: uuid.NewString(), Name: name, State: "OK"}
+	f.Snapshots[snapshot.Uuid] = snapshot
+	return snapshot
+}
+
+func (f *fakeWaldur) instanceURL(resource *fakeResource) string {
+	return fmt.Sprintf("%s/api/openstack-instances/%s/", f.URL(), resource.InstanceUuid)
+}
+
+func (f *fakeWaldur) restoreBackup(w http.ResponseWriter, r *http.Request) {
+	payload := map[string]any{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	_, ok := f.Snapshots[r.PathValue("uuid")]
+	f.mu.Unlock()
+	if !ok {
+		notFound(w)
+		return
+	}
+	name, _ := payload["name"].(string)
+
+	resource := f.AddResource(name, "")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource.State = "Creating"
+	resource.Pending = append([]string{}, f.CreateTransitions...)
+	f.Restorations = append(f.Restorations, payload)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"uuid": resource.InstanceUuid,
+		"url":  f.instanceURL(resource),
+		"name": name,
+	})
+}
+
+func (f *fakeWaldur) listResources(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := r.URL.Query()
+	scope, name, project := query.Get("scope"), query.Get("name_exact"), query.Get("project_uuid")
+	resources := []map[string]any{}
+	for _, resource := range f.resources {
+		if (scope != "" && f.instanceURL(resource) != scope) || (name != "" && resource.Name != name) || (project != "" && resource.ProjectUuid != project) {
+			continue
+		}
+		item := map[string]any{
+			"uuid":          resource.Uuid,
+			"name":          resource.Name,
+			"state":         resource.State,
+			"resource_uuid": resource.InstanceUuid,
+			"description":   resource.Description,
+			"created":       resource.Created.Format(time.RFC3339),
+		}
+		if resource.Terminated {
+			item["state"] = "Terminated"
+		}
+		if resource.Attributes != nil {
+			item["attributes"] = resource.Attributes
+		}
+		resources = append(resources, item)
+	}
+	sortByUuid(resources)
+	writePage(w, r, resources)
+}
@@ -0,0 +1,91 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// instanceResourceType is the marketplace resource type of OpenStack instances
+const instanceResourceType = "OpenStack.Instance"
+
+// authorizeKeyScript appends the public key to the user's authorized keys unless already present
+const authorizeKeyScript = `umask 077
+mkdir -p ~/.ssh
+grep -qxF %[1]q ~/.ssh/authorized_keys 2>/dev/null || echo %[1]q >> ~/.ssh/authorized_keys
+`
+
+// validateImport checks the UUID of the resource to adopt and that credentials to reach it are configured
+func validateImport(resourceUuid, sshKeyPath string) error {
+	if _, err := uuid.Parse(resourceUuid); err != nil {
+		return fmt.Errorf("invalid --waldur-import-resource-uuid %q: %w", resourceUuid, err)
+	}
+	if sshKeyPath == "" {
+		return fmt.Errorf("Waldur requires the --waldur-import-ssh-key-path option to install the machine's SSH key on the adopted instance")
+	}
+	return nil
+}
+
+// verifyImportedResource checks that the resource to adopt is an instance of the configured project
+func (d *Driver) verifyImportedResource(client WaldurAPI) error {
+	resource, err := d.getWaldurResource(client)
+	if err != nil {
+		return err
+	}
+	if resource.ProjectUuid == nil || resource.ProjectUuid.String() != d.ProjectUuid {
+		return fmt.Errorf("resource %s does not belong to project %s", d.ResourceUuid, d.ProjectUuid)
+	}
+	if resource.ResourceType != nil && *resource.ResourceType != instanceResourceType {
+		return fmt.Errorf("resource %s is a %s, only %s resources can be adopted", d.ResourceUuid, *resource.ResourceType, instanceResourceType)
+	}
+	runtimeState := ""
+	if resource.BackendMetadata != nil && resource.BackendMetadata.RuntimeState != nil {
+		runtimeState = *resource.BackendMetadata.RuntimeState
+	}
+	if runtimeState != "ACTIVE" {
+		return fmt.Errorf("instance of resource %s is %s, start it before adopting it", d.ResourceUuid, runtimeState)
+	}
+	if resource.Description != nil && *resource.Description != "" {
+		d.ImportedDescription = *resource.Description
+		log.Infof("The description %q of resource %s is kept under %s in the driver's description", d.ImportedDescription, d.ResourceUuid, metadataImported)
+	}
+	return nil
+}
+
+// importInstance adopts the existing resource instead of ordering an instance: it verifies
+// the resource, installs the machine's public key with the provided credentials and fills
+// in ResourceUuid and IPAddress. ResourceUuid stays empty on failure, so that removing the
+// machine leaves the instance alone.
+func (d *Driver) importInstance(client WaldurAPI, publicKey []byte) (err error) {
+	log.Infof("Adopting resource %s as %s...", d.ImportResourceUuid, d.GetMachineName())
+	d.ResourceUuid = d.ImportResourceUuid
+	defer func() {
+		if err != nil {
+			d.ResourceUuid = ""
+			d.IPAddress = ""
+			d.ImportedDescription = ""
+		}
+	}()
+
+	if err := d.verifyImportedResource(client); err != nil {
+		return err
+	}
+	if err := d.waitForActive(client); err != nil {
+		return err
+	}
+
+	command := fmt.Sprintf(authorizeKeyScript, strings.TrimSpace(string(publicKey)))
+	if output, err := runSSHCommandWithKey(d, d.ImportSSHKeyPath, command); err != nil {
+		return fmt.Errorf("failed to install the SSH key on %s: %w, output: %s", d.GetMachineName(), err, output)
+	}
+	if _, err := runSSHCommand(d, "exit 0"); err != nil {
+		return fmt.Errorf("installed SSH key is not accepted by %s: %w", d.GetMachineName(), err)
+	}
+
+	d.describeResource(client)
+	log.Infof("Adopted resource %s as %s", d.ResourceUuid, d.GetMachineName())
+	log.Warnf("Resource %s is now managed like the instances the driver creates, gc -terminate will terminate it once %s is gone", d.ResourceUuid, d.GetMachineName())
+	return nil
+}
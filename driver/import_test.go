@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// sshKeyCall is a command run over SSH with the key it was run with
+type sshKeyCall struct {
+	KeyPath string
+	Command string
+}
+
+// recordSSHWithKey replaces SSH with a recorder of the commands and keys used on the machine
+func recordSSHWithKey(t *testing.T) *[]sshKeyCall {
+	calls := []sshKeyCall{}
+	original := runSSHCommandWithKey
+	runSSHCommandWithKey = func(d *Driver, keyPath, command string) (string, error) {
+		calls = append(calls, sshKeyCall{KeyPath: keyPath, Command: command})
+		return "", nil
+	}
+	t.Cleanup(func() {
+		runSSHCommandWithKey = original
+	})
+	return &calls
+}
+
+// newImportDriver returns a driver adopting a new active resource of its project
+func newImportDriver(t *testing.T, f *fakeWaldur, runtimeState string) (*Driver, *fakeResource) {
+	d := newTestDriver(t, f)
+	resource := f.AddResource("existing-vm", runtimeState)
+	resource.ProjectUuid = d.ProjectUuid
+	d.ImportResourceUuid = resource.Uuid
+	d.ImportSSHKeyPath = "/keys/admin"
+	return d, resource
+}
+
+func TestCreateImportsResource(t *testing.T) {
+	f := newFakeWaldur(t)
+	d, resource := newImportDriver(t, f, "ACTIVE")
+	resource.Description = "Jump host of the data team"
+	calls := recordSSHWithKey(t)
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	metadata := managedMetadata(waldurclient.Resource{Description: &resource.Description})
+	if metadata[metadataMachine] != d.MachineName || metadata[metadataImported] != "Jump host of the data team" {
+		t.Errorf("the description does not keep the original one: %q", resource.Description)
+	}
+	if len(f.Orders) != 0 {
+		t.Errorf("adopting should not order, got %v", f.Orders)
+	}
+	if d.ResourceUuid != resource.Uuid || d.IPAddress != "192.168.42.10" {
+		t.Errorf("unexpected resource %q and IP %q", d.ResourceUuid, d.IPAddress)
+	}
+	if len(*calls) != 2 {
+		t.Fatalf("unexpected SSH calls %v", *calls)
+	}
+	if (*calls)[0].KeyPath != "/keys/admin" || !strings.Contains((*calls)[0].Command, "authorized_keys") {
+		t.Errorf("key was not installed with the provided credentials: %+v", (*calls)[0])
+	}
+	if (*calls)[1].KeyPath != d.GetSSHKeyPath() {
+		t.Errorf("installed key was not verified: %+v", (*calls)[1])
+	}
+}
+
+func TestCreateImportRejectsOtherProject(t *testing.T) {
+	f := newFakeWaldur(t)
+	d, resource := newImportDriver(t, f, "ACTIVE")
+	resource.ProjectUuid = uuid.NewString()
+	calls := recordSSHWithKey(t)
+
+	err := d.Create()
+	if err == nil || !strings.Contains(err.Error(), "does not belong to project") {
+		t.Fatalf("expected a project mismatch, got %v", err)
+	}
+	if d.ResourceUuid != "" || len(*calls) != 0 {
+		t.Errorf("failed adoption left resource %q and SSH calls %v", d.ResourceUuid, *calls)
+	}
+}
+
+func TestCreateImportRequiresRunningInstance(t *testing.T) {
+	f := newFakeWaldur(t)
+	d, _ := newImportDriver(t, f, "SHUTOFF")
+	recordSSHWithKey(t)
+
+	if err := d.Create(); err == nil || !strings.Contains(err.Error(), "start it before adopting it") {
+		t.Fatalf("expected a stopped instance error, got %v", err)
+	}
+	if d.ResourceUuid != "" {
+		t.Errorf("failed adoption left resource %q", d.ResourceUuid)
+	}
+}
+
+func TestValidateImport(t *testing.T) {
+	if err := validateImport(uuid.NewString(), "/keys/admin"); err != nil {
+		t.Error(err)
+	}
+	if err := validateImport("vm-1", "/keys/admin"); err == nil {
+		t.Error("expected an error for an invalid resource UUID")
+	}
+	if err := validateImport(uuid.NewString(), ""); err == nil {
+		t.Error("expected an error without credentials")
+	}
+}
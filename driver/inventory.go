@@ -0,0 +1,188 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// Inventory kinds accepted by ListInventory
+const (
+	InventoryProjects       = "projects"
+	InventoryOfferings      = "offerings"
+	InventoryFlavors        = "flavors"
+	InventoryImages         = "images"
+	InventoryVolumeTypes    = "volume-types"
+	InventorySubnets        = "subnets"
+	InventorySecurityGroups = "security-groups"
+)
+
+// InventoryKinds lists the inventory kinds, the tenant ones require OfferingUuid
+var InventoryKinds = []string{
+	InventoryProjects,
+	InventoryOfferings,
+	InventoryFlavors,
+	InventoryImages,
+	InventoryVolumeTypes,
+	InventorySubnets,
+	InventorySecurityGroups,
+}
+
+// InventoryItem is a Waldur object the driver flags refer to by UUID
+type InventoryItem struct {
+	Uuid    string `json:"uuid"`
+	Name    string `json:"name"`
+	Details string `json:"details,omitempty"`
+}
+
+// newInventoryItem returns the item, empty when the object has no UUID
+func newInventoryItem(id *uuid.UUID, name *string, details string) InventoryItem {
+	item := InventoryItem{Details: details}
+	if id != nil {
+		item.Uuid = id.String()
+	}
+	if name != nil {
+		item.Name = *name
+	}
+	return item
+}
+
+// ListInventory lists the objects of the kind reachable with the API token. Projects and
+// offerings are listed across the token's access, the other kinds in the tenant of OfferingUuid.
+func (d *Driver) ListInventory(kind string) ([]InventoryItem, error) {
+	client, err := d.getWaldurClient()
+	if err != nil {
+		log.Errorf("Error creating Waldur client %s", err)
+		return nil, err
+	}
+	ctx := context.Background()
+	items := []InventoryItem{}
+
+	switch kind {
+	case InventoryProjects:
+		projects, err := listAll(d, "project listing", func(page, pageSize int) (int, []byte, *[]waldurclient.Project, error) {
+			resp, err := client.ProjectsListWithResponse(ctx, &waldurclient.ProjectsListParams{Page: &page, PageSize: &pageSize})
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			return resp.StatusCode(), resp.Body, resp.JSON200, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, project := range projects {
+			items = append(items, newInventoryItem(project.Uuid, project.Name, ""))
+		}
+		return items, nil
+	case InventoryOfferings:
+		types := []string{instanceResourceType}
+		offerings, err := listAll(d, "offering listing", func(page, pageSize int) (int, []byte, *[]waldurclient.PublicOfferingDetails, error) {
+			resp, err := client.MarketplacePublicOfferingsListWithResponse(ctx, &waldurclient.MarketplacePublicOfferingsListParams{Type: &types, Page: &page, PageSize: &pageSize})
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			return resp.StatusCode(), resp.Body, resp.JSON200, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, offering := range offerings {
+			details := ""
+			if offering.ScopeUuid != nil {
+				details = "tenant " + offering.ScopeUuid.String()
+			}
+			items = append(items, newInventoryItem(offering.Uuid, offering.Name, details))
+		}
+		return items, nil
+	case InventoryFlavors, InventoryImages, InventoryVolumeTypes, InventorySubnets, InventorySecurityGroups:
+	default:
+		return nil, fmt.Errorf("unknown inventory kind %q", kind)
+	}
+
+	if d.OfferingUuid == "" {
+		return nil, fmt.Errorf("listing %s requires the offering UUID", kind)
+	}
+	tenantUuid, err := d.getTenantUuid(client)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case InventoryFlavors:
+		flavors, err := listAll(d, "flavor listing", func(page, pageSize int) (int, []byte, *[]waldurclient.OpenStackFlavor, error) {
+			resp, err := client.OpenstackFlavorsListWithResponse(ctx, &waldurclient.OpenstackFlavorsListParams{TenantUuid: &tenantUuid, Page: &page, PageSize: &pageSize})
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			return resp.StatusCode(), resp.Body, resp.JSON200, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, flavor := range flavors {
+			details := ""
+			if flavor.Cores != nil && flavor.Ram != nil {
+				details = fmt.Sprintf("%d vCPU, %d MB RAM", *flavor.Cores, *flavor.Ram)
+			}
+			items = append(items, newInventoryItem(flavor.Uuid, flavor.Name, details))
+		}
+	case InventoryImages:
+		images, err := listAll(d, "image listing", func(page, pageSize int) (int, []byte, *[]waldurclient.OpenStackImage, error) {
+			resp, err := client.OpenstackImagesListWithResponse(ctx, &waldurclient.OpenstackImagesListParams{TenantUuid: &tenantUuid, Page: &page, PageSize: &pageSize})
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			return resp.StatusCode(), resp.Body, resp.JSON200, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range images {
+			details := ""
+			if image.MinDisk != nil && *image.MinDisk > 0 {
+				details = fmt.Sprintf("min disk %d MB", *image.MinDisk)
+			}
+			items = append(items, newInventoryItem(image.Uuid, image.Name, details))
+		}
+	case InventoryVolumeTypes:
+		volumeTypes, err := listAll(d, "volume type listing", func(page, pageSize int) (int, []byte, *[]waldurclient.OpenStackVolumeType, error) {
+			resp, err := client.OpenstackVolumeTypesListWithResponse(ctx, &waldurclient.OpenstackVolumeTypesListParams{TenantUuid: &tenantUuid, Page: &page, PageSize: &pageSize})
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			return resp.StatusCode(), resp.Body, resp.JSON200, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, volumeType := range volumeTypes {
+			items = append(items, newInventoryItem(volumeType.Uuid, volumeType.Name, stringOf(volumeType.Description)))
+		}
+	case InventorySubnets:
+		subnets, err := d.listInternalSubnets(client)
+		if err != nil {
+			return nil, err
+		}
+		for _, subnet := range subnets {
+			items = append(items, newInventoryItem(subnet.Uuid, subnet.Name, stringOf(subnet.Cidr)))
+		}
+	case InventorySecurityGroups:
+		groups, err := listAll(d, "security group listing", func(page, pageSize int) (int, []byte, *[]waldurclient.OpenStackSecurityGroup, error) {
+			resp, err := client.OpenstackSecurityGroupsListWithResponse(ctx, &waldurclient.OpenstackSecurityGroupsListParams{TenantUuid: &tenantUuid, Page: &page, PageSize: &pageSize})
+			if err != nil {
+				return 0, nil, nil, err
+			}
+			return resp.StatusCode(), resp.Body, resp.JSON200, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			items = append(items, newInventoryItem(group.Uuid, group.Name, stringOf(group.Description)))
+		}
+	}
+	return items, nil
+}
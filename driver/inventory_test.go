@@ -0,0 +1,95 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestListInventoryOfTenant(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	tenantUuid := uuid.NewString()
+	f.SetObject("marketplace-public-offerings", d.OfferingUuid, map[string]any{"scope_uuid": tenantUuid})
+	flavorUuid := uuid.NewString()
+	f.AddListItem("openstack-flavors", map[string]any{"uuid": flavorUuid, "name": "m1.large", "cores": 4, "ram": 8192, "tenant_uuid": tenantUuid})
+	f.AddListItem("openstack-flavors", map[string]any{"uuid": uuid.NewString(), "name": "other", "tenant_uuid": uuid.NewString()})
+	subnetUuid := addSubnet(f, tenantUuid, "dev-sub-net", "")
+	group := f.AddSecurityGroup(tenantUuid, "default")
+
+	flavors, err := d.ListInventory(InventoryFlavors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flavors) != 1 || flavors[0].Uuid != flavorUuid || flavors[0].Details != "4 vCPU, 8192 MB RAM" {
+		t.Errorf("unexpected flavors %+v", flavors)
+	}
+	subnets, err := d.ListInventory(InventorySubnets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subnets) != 1 || subnets[0].Uuid != subnetUuid || subnets[0].Details != "192.168.42.0/24" {
+		t.Errorf("unexpected subnets %+v", subnets)
+	}
+	groups, err := d.ListInventory(InventorySecurityGroups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Uuid != group.Uuid || groups[0].Name != "default" {
+		t.Errorf("unexpected security groups %+v", groups)
+	}
+}
+
+func TestListInventoryOfferings(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.OfferingUuid = ""
+	offeringUuid := uuid.NewString()
+	f.AddListItem("marketplace-public-offerings", map[string]any{"uuid": offeringUuid, "name": "OpenStack VMs", "type": "OpenStack.Instance"})
+	f.AddListItem("marketplace-public-offerings", map[string]any{"uuid": uuid.NewString(), "name": "Tenants", "type": "OpenStack.Tenant"})
+
+	offerings, err := d.ListInventory(InventoryOfferings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offerings) != 1 || offerings[0].Uuid != offeringUuid {
+		t.Errorf("unexpected offerings %+v", offerings)
+	}
+}
+
+func TestListInventoryRequiresOffering(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.OfferingUuid = ""
+
+	if _, err := d.ListInventory(InventoryImages); err == nil || !strings.Contains(err.Error(), "requires the offering UUID") {
+		t.Fatalf("expected an offering error, got %v", err)
+	}
+	if _, err := d.ListInventory("keypairs"); err == nil {
+		t.Fatal("expected an unknown kind error")
+	}
+}
+
+func TestListInventoryIsPaged(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	shortPages(t)
+	tenantUuid := uuid.NewString()
+	f.SetObject("marketplace-public-offerings", d.OfferingUuid, map[string]any{"scope_uuid": tenantUuid})
+	for _, name := range []string{"dev", "staging", "prod"} {
+		f.AddListItem("projects", map[string]any{"uuid": uuid.NewString(), "name": name})
+		f.AddListItem("openstack-images", map[string]any{"uuid": uuid.NewString(), "name": name, "tenant_uuid": tenantUuid})
+		f.AddSecurityGroup(tenantUuid, name)
+	}
+
+	for _, kind := range []string{InventoryProjects, InventoryImages, InventorySecurityGroups} {
+		items, err := d.ListInventory(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 3 {
+			t.Errorf("expected all 3 %s across pages, got %+v", kind, items)
+		}
+	}
+}
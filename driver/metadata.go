@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// Version of the driver, set at build time with -ldflags "-X github.com/waldur/waldur-rancher-node-driver/driver.Version=..."
+var Version = "dev"
+
+// Metadata keys set on the instances created by the driver
+const (
+	metadataCluster  = "rancher-cluster"
+	metadataNodePool = "rancher-node-pool"
+	metadataMachine  = "rancher-machine"
+	metadataCreator  = "rancher-creator"
+	metadataVersion  = "waldur-driver-version"
+	// metadataImported keeps the description an adopted resource had before the driver's
+	metadataImported = "rancher-imported-description"
+)
+
+// resourceMetadata returns the metadata identifying the machine, its cluster and node pool
+func (d *Driver) resourceMetadata() map[string]string {
+	metadata := map[string]string{
+		metadataMachine: d.GetMachineName(),
+		metadataVersion: Version,
+	}
+	for key, value := range map[string]string{
+		metadataCluster:  d.ClusterName,
+		metadataNodePool: d.NodePool,
+		metadataCreator:  d.Creator,
+		metadataImported: d.ImportedDescription,
+	} {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
+// resourceDescription returns the description of the resource shown in Waldur's resource
+// views. The driver sets no OpenStack metadata or tags: neither the instance order attributes
+// nor the instance API of Waldur accept them, so the metadata is carried as a JSON object after
+// the machine name, which managedMetadata reads back. Rancher only gives drivers the machine
+// name, the cluster, node pool and creator come from the driver options.
+func (d *Driver) resourceDescription() string {
+	// Marshalling a map of strings cannot fail, keys are sorted
+	metadata, _ := json.Marshal(d.resourceMetadata())
+	return fmt.Sprintf("%s%s %s", resourceDescriptionPrefix, d.GetMachineName(), metadata)
+}
+
+// describeResource sets the description of the machine's resource once it is known, after an
+// order, a backup restoration or an import. Failures are only logged as the description is
+// informational.
+func (d *Driver) describeResource(client WaldurAPI) {
+	resourceUuid, err := uuid.Parse(d.ResourceUuid)
+	if err != nil {
+		log.Warnf("Unable to describe resource %q: %v", d.ResourceUuid, err)
+		return
+	}
+	description := d.resourceDescription()
+	resp, err := client.MarketplaceResourcesPartialUpdateWithResponse(context.Background(), resourceUuid, waldurclient.MarketplaceResourcesPartialUpdateJSONRequestBody{
+		Description: &description,
+	})
+	if err != nil {
+		log.Warnf("Unable to describe resource %s: %v", d.ResourceUuid, err)
+		return
+	}
+	if resp.StatusCode() != 200 {
+		log.Warnf("Unable to describe resource %s: %v", d.ResourceUuid, d.apiError("resource update", d.ResourceUuid, resp.StatusCode(), resp.Body))
+	}
+}
@@ -0,0 +1,88 @@
+package driver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	waldurclient "github.com/waldur/go-client"
+)
+
+func TestCreateDescribesResource(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.ClusterName = "prod"
+	d.NodePool = "workers"
+	d.Creator = "alice"
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	attributes := f.Orders[0]["attributes"].(map[string]any)
+	for _, key := range []string{"metadata", "tags"} {
+		if value, ok := attributes[key]; ok {
+			t.Errorf("unexpected %s order attribute %v", key, value)
+		}
+	}
+	expected := map[string]string{
+		"rancher-cluster":       "prod",
+		"rancher-node-pool":     "workers",
+		"rancher-machine":       "node-1",
+		"rancher-creator":       "alice",
+		"waldur-driver-version": Version,
+	}
+	description, _ := attributes["description"].(string)
+	if metadata := managedMetadata(waldurclient.Resource{Description: &description}); !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("unexpected order description %q", description)
+	}
+	if resource := f.Resource(d.ResourceUuid); resource == nil || resource.Description != description {
+		t.Errorf("the resource was not described")
+	}
+}
+
+func TestResourceMetadataOmitsUnsetValues(t *testing.T) {
+	d := NewDriver("node-1", t.TempDir())
+
+	metadata := d.resourceMetadata()
+	if !reflect.DeepEqual(metadata, map[string]string{"rancher-machine": "node-1", "waldur-driver-version": Version}) {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+}
+
+func TestManagedMetadataRoundTrip(t *testing.T) {
+	d := NewDriver("node-1", t.TempDir())
+	// Separators of the metadata must not break parsing when they appear in values
+	d.ClusterName = "prod, eu=west (main)"
+	d.Creator = `"alice"`
+
+	description := d.resourceDescription()
+	metadata := managedMetadata(waldurclient.Resource{Description: &description})
+	if !reflect.DeepEqual(metadata, d.resourceMetadata()) {
+		t.Errorf("unexpected metadata %v parsed from %q", metadata, description)
+	}
+
+	for _, description := range []string{
+		"Primary database",
+		"Rancher node worker-2",
+		"Rancher node worker-2 (rancher-machine=worker-2)",
+		`Rancher node worker-2 {"rancher-cluster": "prod"}`,
+	} {
+		if metadata := managedMetadata(waldurclient.Resource{Description: &description}); metadata != nil {
+			t.Errorf("unexpected metadata %v parsed from %q", metadata, description)
+		}
+	}
+}
+
+func TestImportDescribesResource(t *testing.T) {
+	f := newFakeWaldur(t)
+	d, resource := newImportDriver(t, f, "ACTIVE")
+	d.ClusterName = "prod"
+	recordSSHWithKey(t)
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if resource.Description != d.resourceDescription() || !strings.Contains(resource.Description, `"rancher-cluster":"prod"`) {
+		t.Errorf("unexpected description %q", resource.Description)
+	}
+}
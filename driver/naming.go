@@ -0,0 +1,170 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// maxResourceNameLength is the length limit of a hostname label, which OpenStack derives from the instance name
+const maxResourceNameLength = 63
+
+// invalidNameCharacters matches the runs of characters not allowed in hostnames
+var invalidNameCharacters = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// repeatedHyphens matches the runs of hyphens left by the replacement of invalid characters
+var repeatedHyphens = regexp.MustCompile(`-{2,}`)
+
+// nameTemplateData holds the values a naming template can refer to
+type nameTemplateData struct {
+	Machine  string
+	Cluster  string
+	NodePool string
+	Project  string
+}
+
+// nameHashLength is the length of the hash ending the names shortened to maxResourceNameLength
+const nameHashLength = 8
+
+// parseNameTemplate parses the naming template, rejecting references to unknown fields and
+// templates which do not render the machine name, as the nodes of a pool would share a name
+func parseNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --waldur-name-template: %w", err)
+	}
+	rendered := strings.Builder{}
+	if err := tmpl.Execute(&rendered, nameTemplateData{Machine: "\x00"}); err != nil {
+		return nil, fmt.Errorf("invalid --waldur-name-template: %w", err)
+	}
+	if !strings.Contains(rendered.String(), "\x00") {
+		return nil, fmt.Errorf("invalid --waldur-name-template %q: it must refer to {{.Machine}}", text)
+	}
+	return tmpl, nil
+}
+
+// sanitizeName lowercases the name and reduces it to a hostname label: letters, digits and
+// single hyphens, neither leading nor trailing, at most 63 characters long. Longer names are
+// cut and end in a hash of the whole name instead, so that names differing only in their
+// machine suffix stay distinct.
+func sanitizeName(name string) string {
+	name = strings.ToLower(name)
+	name = invalidNameCharacters.ReplaceAllString(name, "-")
+	name = repeatedHyphens.ReplaceAllString(name, "-")
+	name = strings.Trim(name, "-")
+	if len(name) > maxResourceNameLength {
+		hash := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:nameHashLength]
+		name = strings.TrimRight(name[:maxResourceNameLength-nameHashLength-1], "-") + "-" + hash
+	}
+	return name
+}
+
+// getProjectName fetches the name of the configured project
+func (d *Driver) getProjectName(client WaldurAPI) (string, error) {
+	projectUuid, err := uuid.Parse(d.ProjectUuid)
+	if err != nil {
+		log.Errorf("Error converting project UUID string to UUID object: %s", err)
+		return "", err
+	}
+	resp, err := client.ProjectsRetrieveWithResponse(context.Background(), projectUuid, &waldurclient.ProjectsRetrieveParams{})
+	if err != nil {
+		log.Errorf("Error calling project retrieval API: %v", err)
+		return "", err
+	}
+	if resp.StatusCode() != 200 {
+		return "", d.apiError("project retrieval", "", resp.StatusCode(), resp.Body)
+	}
+	if resp.JSON200.Name == nil {
+		return "", nil
+	}
+	return *resp.JSON200.Name, nil
+}
+
+// renderResourceName renders the naming template, the machine name is used without a template
+func (d *Driver) renderResourceName(client WaldurAPI) (string, error) {
+	if d.NameTemplate == "" {
+		return d.GetMachineName(), nil
+	}
+	tmpl, err := parseNameTemplate(d.NameTemplate)
+	if err != nil {
+		return "", err
+	}
+	data := nameTemplateData{
+		Machine:  d.GetMachineName(),
+		Cluster:  d.ClusterName,
+		NodePool: d.NodePool,
+	}
+	// The project is only fetched when the template refers to it
+	if strings.Contains(d.NameTemplate, ".Project") {
+		if data.Project, err = d.getProjectName(client); err != nil {
+			return "", err
+		}
+	}
+
+	rendered := strings.Builder{}
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("failed to render the name of %s: %w", d.GetMachineName(), err)
+	}
+	name := sanitizeName(rendered.String())
+	if name == "" {
+		return "", fmt.Errorf("name template %q renders an empty name for %s", d.NameTemplate, d.GetMachineName())
+	}
+	return name, nil
+}
+
+// checkNameUnique fails when a resource of the project other than a terminated one already has the name
+func (d *Driver) checkNameUnique(client WaldurAPI, name string) error {
+	projectUuid, err := uuid.Parse(d.ProjectUuid)
+	if err != nil {
+		log.Errorf("Error converting project UUID string to UUID object: %s", err)
+		return err
+	}
+	resources, err := listAll(d, "resource listing", func(page, pageSize int) (int, []byte, *[]waldurclient.Resource, error) {
+		resp, err := client.MarketplaceResourcesListWithResponse(context.Background(), &waldurclient.MarketplaceResourcesListParams{
+			ProjectUuid: &projectUuid,
+			NameExact:   &name,
+			Page:        &page,
+			PageSize:    &pageSize,
+		})
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return resp.StatusCode(), resp.Body, resp.JSON200, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, resource := range resources {
+		if resource.State != nil && *resource.State == waldurclient.ResourceStateTerminated {
+			continue
+		}
+		return fmt.Errorf("project %s already has a resource named %s (%s)", d.ProjectUuid, name, resource.Uuid)
+	}
+	return nil
+}
+
+// resolveResourceName renders the name of the resource and checks its uniqueness when
+// configured. The name is persisted in ResourceName.
+func (d *Driver) resolveResourceName(client WaldurAPI) error {
+	name, err := d.renderResourceName(client)
+	if err != nil {
+		return err
+	}
+	if d.NameUnique {
+		if err := d.checkNameUnique(client, name); err != nil {
+			return err
+		}
+	}
+	if name != d.GetMachineName() {
+		log.Infof("Naming the resource of %s %s", d.GetMachineName(), name)
+	}
+	d.ResourceName = name
+	return nil
+}
@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := map[string]string{
+		"node-1":                       "node-1",
+		"K8s Prod_Cluster.node 1":      "k8s-prod-cluster-node-1",
+		"--Team A -- workers--":        "team-a-workers",
+		"ÄÖÜ":                          "",
+		strings.Repeat("a", 61) + "-b": strings.Repeat("a", 61) + "-b",
+	}
+	for name, expected := range tests {
+		if sanitized := sanitizeName(name); sanitized != expected {
+			t.Errorf("sanitizeName(%q) = %q, expected %q", name, sanitized, expected)
+		}
+	}
+}
+
+func TestSanitizeNameKeepsLongNamesDistinct(t *testing.T) {
+	prefix := strings.Repeat("data-science-", 5)
+	first, second := sanitizeName(prefix+"workers-abc12"), sanitizeName(prefix+"workers-def34")
+	if first == second {
+		t.Errorf("long names of different machines collide as %q", first)
+	}
+	for _, name := range []string{first, second} {
+		if len(name) > maxResourceNameLength || !strings.HasPrefix(name, "data-science-") {
+			t.Errorf("unexpected shortened name %q", name)
+		}
+	}
+	if sanitizeName(prefix+"workers-abc12") != first {
+		t.Error("shortened names should be stable")
+	}
+}
+
+func TestParseNameTemplate(t *testing.T) {
+	if _, err := parseNameTemplate("{{.Project}}-{{.Cluster}}-{{.NodePool}}-{{.Machine}}"); err != nil {
+		t.Error(err)
+	}
+	for _, text := range []string{"{{.Machine", "{{.Tenant}}-{{.Machine}}", "{{.Cluster}}-{{.NodePool}}", "{{if .Machine}}node{{end}}"} {
+		if _, err := parseNameTemplate(text); err == nil {
+			t.Errorf("expected an error for %q", text)
+		}
+	}
+}
+
+func TestCreateWithNameTemplate(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	f.SetObject("projects", d.ProjectUuid, map[string]any{"name": "Data Science"})
+	d.NameTemplate = "{{.Project}}-{{.Cluster}}-{{.Machine}}"
+	d.ClusterName = "prod_eu"
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	attributes := f.Orders[0]["attributes"].(map[string]any)
+	if attributes["name"] != "data-science-prod-eu-node-1" || d.ResourceName != "data-science-prod-eu-node-1" {
+		t.Errorf("unexpected name %v, persisted %q", attributes["name"], d.ResourceName)
+	}
+}
+
+func TestCreateRejectsDuplicateName(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.NameUnique = true
+	existing := f.AddResource("node-1", "ACTIVE")
+	existing.ProjectUuid = d.ProjectUuid
+
+	err := d.Create()
+	if err == nil || !strings.Contains(err.Error(), "already has a resource named node-1") {
+		t.Fatalf("expected a duplicate name error, got %v", err)
+	}
+	if len(f.Orders) != 0 {
+		t.Errorf("unexpected orders %v", f.Orders)
+	}
+}
+
+func TestCreateUniqueNameIgnoresOtherProjects(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.NameUnique = true
+	f.AddResource("node-1", "ACTIVE")
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+}
+
+func TestUniqueNameLooksBeyondTerminatedResources(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	shortPages(t)
+	d.NameUnique = true
+	for range 2 {
+		terminated := f.AddResource("node-1", "DELETED")
+		terminated.ProjectUuid = d.ProjectUuid
+		terminated.Terminated = true
+	}
+	existing := f.AddResource("node-1", "ACTIVE")
+	existing.ProjectUuid = d.ProjectUuid
+
+	if err := d.Create(); err == nil || !strings.Contains(err.Error(), "already has a resource named node-1") {
+		t.Fatalf("expected a duplicate name error, got %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// getWaldurOffering fetches the marketplace offering the driver orders instances from
+func (d *Driver) getWaldurOffering(client WaldurAPI) (*waldurclient.PublicOfferingDetails, error) {
+	ctx := context.Background()
+	offeringUuid, err := uuid.Parse(d.OfferingUuid)
+	if err != nil {
+		log.Errorf("Error converting offering UUID string to UUID object: %s", err)
+		return nil, err
+	}
+	resp, err := client.MarketplacePublicOfferingsRetrieveWithResponse(ctx, offeringUuid, &waldurclient.MarketplacePublicOfferingsRetrieveParams{})
+	if err != nil {
+		log.Errorf("Error calling offering retrieval API: %v", err)
+		return nil, err
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, d.apiError("offering retrieval", "", resp.StatusCode(), resp.Body)
+	}
+
+	return resp.JSON200, nil
+}
+
+// getTenantUuid returns the UUID of the OpenStack tenant backing the offering
+func (d *Driver) getTenantUuid(client WaldurAPI) (uuid.UUID, error) {
+	offering, err := d.getWaldurOffering(client)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if offering.ScopeUuid == nil {
+		return uuid.Nil, fmt.Errorf("offering %s is not connected to an OpenStack tenant", d.OfferingUuid)
+	}
+	return *offering.ScopeUuid, nil
+}
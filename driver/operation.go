@@ -0,0 +1,219 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+var (
+	operationPollInterval = 5 * time.Second
+	operationPollTimeout  = 10 * time.Minute
+)
+
+// OperationMetrics receives the outcome of every Waldur operation performed by the driver
+type OperationMetrics interface {
+	ObserveOperation(name string, duration time.Duration, err error)
+}
+
+// logMetrics is the default OperationMetrics implementation, it reports operations in the debug log
+type logMetrics struct{}
+
+func (logMetrics) ObserveOperation(name string, duration time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	log.Debugf("Waldur operation %s finished in %v, outcome: %s", name, duration, outcome)
+}
+
+// operationCall performs the API request of an operation against the given UUID
+// and returns the status code and body of the response
+type operationCall func(ctx context.Context, client WaldurAPI, id uuid.UUID) (int, []byte, error)
+
+// operation describes an action performed on the machine's Waldur resource
+type operation struct {
+	// name is used in logs, errors and metrics, e.g. "instance start"
+	name string
+	// onInstance passes the OpenStack instance UUID to call instead of the marketplace resource UUID
+	onInstance bool
+	call       operationCall
+	// expectedStatus is the status code of a successful call
+	expectedStatus int
+	// waitFor lists the runtime states which complete the operation, empty to return right after the call
+	waitFor []string
+	// ignoreNotFound treats a missing resource as success
+	ignoreNotFound bool
+}
+
+func (d *Driver) observe(name string, duration time.Duration, err error) {
+	metrics := d.Metrics
+	if metrics == nil {
+		metrics = logMetrics{}
+	}
+	metrics.ObserveOperation(name, duration, err)
+}
+
+// runOperation performs the operation, validates the response status and waits for the resulting state
+func (d *Driver) runOperation(op operation) (err error) {
+	start := time.Now()
+	defer func() {
+		d.observe(op.name, time.Since(start), err)
+	}()
+
+	log.Infof("Performing %s for %s (%s)", op.name, d.GetMachineName(), d.ResourceUuid)
+	client, err := d.getWaldurClient()
+	if err != nil {
+		log.Errorf("Error creating Waldur client %s", err)
+		return err
+	}
+
+	var target uuid.UUID
+	if op.onInstance {
+		resource, err := d.getWaldurResource(client)
+		if err != nil {
+			if op.ignoreNotFound && errors.Is(err, ErrNotFound) {
+				log.Warnf("Instance %s (%s) is already gone", d.GetMachineName(), d.ResourceUuid)
+				return nil
+			}
+			return err
+		}
+		if resource.ResourceUuid == nil {
+			return fmt.Errorf("resource %s of %s has no backend instance", d.ResourceUuid, d.GetMachineName())
+		}
+		target = *resource.ResourceUuid
+	} else {
+		target, err = uuid.Parse(d.ResourceUuid)
+		if err != nil {
+			log.Errorf("Error converting resource UUID string to UUID object: %s", err)
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	statusCode, body, err := op.call(ctx, client, target)
+	if err != nil {
+		log.Errorf("Error calling %s API: %v", op.name, err)
+		return err
+	}
+
+	if statusCode != op.expectedStatus {
+		err := d.apiError(op.name, d.ResourceUuid, statusCode, body)
+		if op.ignoreNotFound && errors.Is(err, ErrNotFound) {
+			log.Warnf("Instance %s (%s) is already gone", d.GetMachineName(), d.ResourceUuid)
+			return nil
+		}
+		return err
+	}
+
+	if len(op.waitFor) > 0 {
+		if _, err := d.waitForRuntimeState(client, operationPollInterval, operationPollTimeout, op.waitFor...); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Successfully completed %s for %s", op.name, d.GetMachineName())
+	return nil
+}
+
+// waitForRuntimeState polls the Waldur API until the instance reaches one of the given
+// runtime states and returns the resource, failing early if the resource becomes erred.
+func (d *Driver) waitForRuntimeState(client WaldurAPI, interval, timeout time.Duration, states ...string) (*waldurclient.Resource, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for instance %s to reach state %v after %v", d.GetMachineName(), states, timeout)
+		}
+
+		resource, err := d.getWaldurResource(client)
+		if err != nil {
+			return nil, err
+		}
+
+		if resource.State != nil && *resource.State == waldurclient.ResourceStateErred {
+			errMsg := ""
+			if resource.ErrorMessage != nil {
+				errMsg = *resource.ErrorMessage
+			}
+			err := fmt.Errorf("instance %s entered error state: %s", d.GetMachineName(), errMsg)
+			if shortages := parseQuotaShortages([]string{errMsg}); len(shortages) > 0 {
+				return nil, &QuotaError{Shortages: shortages, Err: err}
+			}
+			return nil, err
+		}
+
+		runtimeState := "unknown"
+		if resource.BackendMetadata != nil && resource.BackendMetadata.RuntimeState != nil {
+			runtimeState = *resource.BackendMetadata.RuntimeState
+		}
+		for _, s := range states {
+			if runtimeState == s {
+				return resource, nil
+			}
+		}
+
+		log.Infof("Instance %s runtime state: %s — waiting...", d.GetMachineName(), runtimeState)
+		time.Sleep(interval)
+	}
+}
+
+// waitForTermination polls the Waldur API until the machine's resource is terminated or gone
+func (d *Driver) waitForTermination(client WaldurAPI) error {
+	deadline := time.Now().Add(operationPollTimeout)
+	for {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for instance %s to be terminated after %v", d.GetMachineName(), operationPollTimeout)
+		}
+
+		resource, err := d.getWaldurResource(client)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if resource.State != nil {
+			switch *resource.State {
+			case waldurclient.ResourceStateTerminated:
+				return nil
+			case waldurclient.ResourceStateErred:
+				return fmt.Errorf("termination of instance %s failed", d.GetMachineName())
+			}
+		}
+
+		log.Infof("Waiting for instance %s to be terminated...", d.GetMachineName())
+		time.Sleep(operationPollInterval)
+	}
+}
+
+// terminateOperation builds the operation terminating the marketplace resource with the given extra attributes
+func terminateOperation(name string, extra map[string]any) operation {
+	return operation{
+		name:           name,
+		expectedStatus: 200,
+		ignoreNotFound: true,
+		call: func(ctx context.Context, client WaldurAPI, id uuid.UUID) (int, []byte, error) {
+			values := map[string]any{
+				"delete_volumes":       true,
+				"release_floating_ips": true,
+			}
+			for key, value := range extra {
+				values[key] = value
+			}
+			var attributes any = values
+			payload := waldurclient.MarketplaceResourcesTerminateJSONRequestBody{
+				Attributes: &attributes,
+			}
+			resp, err := client.MarketplaceResourcesTerminateWithResponse(ctx, id, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			return resp.StatusCode(), resp.Body, nil
+		},
+	}
+}
@@ -0,0 +1,108 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// ManagedResource is a resource created by the driver, identified by its metadata
+type ManagedResource struct {
+	Uuid     string
+	Name     string
+	State    string
+	Machine  string
+	Cluster  string
+	NodePool string
+	// Created is when the resource was created, zero when Waldur does not tell
+	Created time.Time
+}
+
+// resourceDescriptionPrefix starts the descriptions set by resourceDescription
+const resourceDescriptionPrefix = "Rancher node "
+
+// managedMetadata returns the driver's metadata of the resource parsed from its description,
+// the JSON object following the machine name. It is nil for other resources.
+func managedMetadata(resource waldurclient.Resource) map[string]string {
+	if resource.Description == nil || !strings.HasPrefix(*resource.Description, resourceDescriptionPrefix) {
+		return nil
+	}
+	// Machine names are hostnames, so the object starts after the first space
+	_, object, ok := strings.Cut(strings.TrimPrefix(*resource.Description, resourceDescriptionPrefix), " ")
+	if !ok {
+		return nil
+	}
+	metadata := map[string]string{}
+	if err := json.Unmarshal([]byte(object), &metadata); err != nil || metadata[metadataMachine] == "" {
+		return nil
+	}
+	return metadata
+}
+
+// ListManagedResources lists the resources of the configured project which were created by the driver
+func (d *Driver) ListManagedResources() ([]ManagedResource, error) {
+	client, err := d.getWaldurClient()
+	if err != nil {
+		log.Errorf("Error creating Waldur client %s", err)
+		return nil, err
+	}
+	projectUuid, err := uuid.Parse(d.ProjectUuid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid project UUID %q: %w", d.ProjectUuid, err)
+	}
+
+	ctx := context.Background()
+	resources, err := listAll(d, "resource listing", func(page, pageSize int) (int, []byte, *[]waldurclient.Resource, error) {
+		resp, err := client.MarketplaceResourcesListWithResponse(ctx, &waldurclient.MarketplaceResourcesListParams{
+			ProjectUuid: &projectUuid,
+			Page:        &page,
+			PageSize:    &pageSize,
+		})
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return resp.StatusCode(), resp.Body, resp.JSON200, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	managed := []ManagedResource{}
+	for _, resource := range resources {
+		metadata := managedMetadata(resource)
+		if metadata == nil || resource.Uuid == nil {
+			continue
+		}
+		item := ManagedResource{
+			Uuid:     resource.Uuid.String(),
+			Machine:  metadata[metadataMachine],
+			Cluster:  metadata[metadataCluster],
+			NodePool: metadata[metadataNodePool],
+		}
+		if resource.Name != nil {
+			item.Name = *resource.Name
+		}
+		if resource.State != nil {
+			item.State = string(*resource.State)
+		}
+		if resource.Created != nil {
+			item.Created = *resource.Created
+		}
+		managed = append(managed, item)
+	}
+	return managed, nil
+}
+
+// TerminateResource terminates a resource of the configured project, e.g. an orphan found
+// with ListManagedResources, releasing its volumes and floating IPs
+func (d *Driver) TerminateResource(resourceUuid string) error {
+	orphan := *d
+	orphan.ResourceUuid = resourceUuid
+	return orphan.runOperation(terminateOperation("orphan removal", nil))
+}
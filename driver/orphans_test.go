@@ -0,0 +1,56 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestListManagedResources(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.ClusterName = "prod"
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	described := f.AddResource("adopted-vm", "ACTIVE")
+	described.ProjectUuid = d.ProjectUuid
+	described.Description = `Rancher node worker-2 {"rancher-cluster":"prod","rancher-machine":"worker-2","waldur-driver-version":"dev"}`
+	unmanaged := f.AddResource("database", "ACTIVE")
+	unmanaged.ProjectUuid = d.ProjectUuid
+	unmanaged.Description = "Primary database"
+	other := f.AddResource("node-9", "ACTIVE")
+	other.ProjectUuid = uuid.NewString()
+	other.Description = `Rancher node node-9 {"rancher-machine":"node-9"}`
+
+	resources, err := d.ListManagedResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	machines := map[string]ManagedResource{}
+	for _, resource := range resources {
+		machines[resource.Machine] = resource
+	}
+	if len(machines) != 2 {
+		t.Fatalf("unexpected managed resources %+v", resources)
+	}
+	if resource := machines["node-1"]; resource.Uuid != d.ResourceUuid || resource.Cluster != "prod" || resource.Created.IsZero() {
+		t.Errorf("unexpected ordered resource %+v", resource)
+	}
+	if resource := machines["worker-2"]; resource.Uuid != described.Uuid || resource.Cluster != "prod" {
+		t.Errorf("unexpected described resource %+v", resource)
+	}
+}
+
+func TestTerminateResource(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	orphan := f.AddResource("node-7", "ACTIVE")
+
+	if err := d.TerminateResource(orphan.Uuid); err != nil {
+		t.Fatal(err)
+	}
+	if !orphan.Terminated || d.ResourceUuid != "" {
+		t.Errorf("orphan terminated %v, driver resource %q", orphan.Terminated, d.ResourceUuid)
+	}
+}
@@ -0,0 +1,36 @@
+package driver
+
+import (
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// listPageSize is the page size requested from Waldur list endpoints, a variable so that
+// tests can exercise paging with few objects
+var listPageSize = 200
+
+// listPage fetches one page of a Waldur list endpoint and returns the response status,
+// body and decoded items
+type listPage[T any] func(page, pageSize int) (int, []byte, *[]T, error)
+
+// listAll fetches the pages of a Waldur list endpoint until a short page and returns all items
+func listAll[T any](d *Driver, operation string, fetch listPage[T]) ([]T, error) {
+	pageSize := listPageSize
+	items := []T{}
+	for page := 1; ; page++ {
+		status, body, pageItems, err := fetch(page, pageSize)
+		if err != nil {
+			log.Errorf("Error calling %s API: %v", operation, err)
+			return nil, err
+		}
+		if status != 200 {
+			return nil, d.apiError(operation, "", status, body)
+		}
+		if pageItems == nil {
+			return items, nil
+		}
+		items = append(items, *pageItems...)
+		if len(*pageItems) < pageSize {
+			return items, nil
+		}
+	}
+}
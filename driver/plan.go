@@ -0,0 +1,126 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// parseLimits parses key=value limit flags into a map of integer limits
+func parseLimits(values []string) (map[string]int, error) {
+	limits := map[string]int{}
+	for _, value := range values {
+		key, amount, found := strings.Cut(value, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid limit %q, expected key=value", value)
+		}
+		number, err := strconv.Atoi(strings.TrimSpace(amount))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of limit %q: %w", key, err)
+		}
+		limits[key] = number
+	}
+	return limits, nil
+}
+
+// findPlan returns the active offering plan matching the configured plan UUID or name
+func (d *Driver) findPlan(offering *waldurclient.PublicOfferingDetails) (*waldurclient.BasePublicPlan, error) {
+	available := []string{}
+	if offering.Plans != nil {
+		for i, plan := range *offering.Plans {
+			if plan.Archived != nil && *plan.Archived {
+				continue
+			}
+			planUuid := ""
+			if plan.Uuid != nil {
+				planUuid = plan.Uuid.String()
+			}
+			if (d.PlanUuid != "" && planUuid == d.PlanUuid) || (d.PlanUuid == "" && plan.Name == d.PlanName) {
+				return &(*offering.Plans)[i], nil
+			}
+			available = append(available, fmt.Sprintf("%s (%s)", plan.Name, planUuid))
+		}
+	}
+
+	requested := d.PlanUuid
+	if requested == "" {
+		requested = d.PlanName
+	}
+	if len(available) == 0 {
+		return nil, fmt.Errorf("plan %s not found, offering %s has no active plans", requested, d.OfferingUuid)
+	}
+	return nil, fmt.Errorf("plan %s not found in offering %s, available plans: %s", requested, d.OfferingUuid, strings.Join(available, ", "))
+}
+
+// validateLimits checks the configured limits against the limit-based components of the offering
+func (d *Driver) validateLimits(offering *waldurclient.PublicOfferingDetails) error {
+	components := map[string]waldurclient.OfferingComponent{}
+	if offering.Components != nil {
+		for _, component := range *offering.Components {
+			if string(component.BillingType) == "limit" {
+				components[component.Type] = component
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(d.Limits))
+	for key := range d.Limits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := d.Limits[key]
+		component, ok := components[key]
+		if !ok {
+			available := make([]string, 0, len(components))
+			for componentType := range components {
+				available = append(available, componentType)
+			}
+			sort.Strings(available)
+			return fmt.Errorf("limit %s is not a limit-based component of offering %s, available components: %s", key, d.OfferingUuid, strings.Join(available, ", "))
+		}
+		if component.MinValue != nil && value < *component.MinValue {
+			return fmt.Errorf("limit %s (%s) must be at least %d, got %d", key, component.Name, *component.MinValue, value)
+		}
+		if component.MaxValue != nil && value > *component.MaxValue {
+			return fmt.Errorf("limit %s (%s) must be at most %d, got %d", key, component.Name, *component.MaxValue, value)
+		}
+	}
+	return nil
+}
+
+// resolveOrderPlan validates the plan and limits against the offering and
+// returns the plan URL for the order, nil if no plan is configured
+func (d *Driver) resolveOrderPlan(client WaldurAPI) (*string, error) {
+	if d.PlanUuid == "" && d.PlanName == "" && len(d.Limits) == 0 {
+		return nil, nil
+	}
+
+	offering, err := d.getWaldurOffering(client)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.validateLimits(offering); err != nil {
+		return nil, err
+	}
+	if d.PlanUuid == "" && d.PlanName == "" {
+		return nil, nil
+	}
+
+	plan, err := d.findPlan(offering)
+	if err != nil {
+		return nil, err
+	}
+	planUri := fmt.Sprintf("%s/api/marketplace-plans/%s/", d.ApiUrl, plan.Uuid)
+	if plan.Url != nil {
+		planUri = *plan.Url
+	}
+	log.Infof("Using plan %s for instance %s", plan.Name, d.GetMachineName())
+	return &planUri, nil
+}
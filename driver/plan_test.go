@@ -0,0 +1,108 @@
+package driver
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseLimits(t *testing.T) {
+	limits, err := parseLimits([]string{"cores=4", " ram = 8192"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits["cores"] != 4 || limits["ram"] != 8192 {
+		t.Errorf("unexpected limits %v", limits)
+	}
+
+	for _, value := range []string{"cores", "=4", "cores=four"} {
+		if _, err := parseLimits([]string{value}); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+// setPlanOffering serves an offering with a basic and an archived plan and a cores limit component
+func setPlanOffering(f *fakeWaldur, d *Driver) string {
+	planUuid := uuid.NewString()
+	f.SetObject("marketplace-public-offerings", d.OfferingUuid, map[string]any{
+		"uuid": d.OfferingUuid,
+		"plans": []map[string]any{
+			{"uuid": planUuid, "url": d.ApiUrl + "/api/marketplace-plans/" + planUuid + "/", "name": "basic"},
+			{"uuid": uuid.NewString(), "name": "legacy", "archived": true},
+		},
+		"components": []map[string]any{
+			{"type": "cores", "name": "Cores", "billing_type": "limit", "min_value": 1, "max_value": 16},
+			{"type": "storage", "name": "Storage", "billing_type": "usage"},
+		},
+	})
+	return planUuid
+}
+
+func TestCreateWithPlanAndLimits(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	planUuid := setPlanOffering(f, d)
+	d.PlanName = "basic"
+	d.Limits = map[string]int{"cores": 4}
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("PreCreateCheck failed: %v", err)
+	}
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	order := f.Orders[0]
+	if order["plan"] != d.ApiUrl+"/api/marketplace-plans/"+planUuid+"/" {
+		t.Errorf("unexpected plan %v", order["plan"])
+	}
+	if limits := order["limits"].(map[string]any); limits["cores"] != float64(4) {
+		t.Errorf("unexpected limits %v", limits)
+	}
+}
+
+func TestCreateResolvesPlanWithoutPreCreateCheck(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	planUuid := setPlanOffering(f, d)
+	d.PlanUuid = planUuid
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if plan := f.Orders[0]["plan"]; plan != d.ApiUrl+"/api/marketplace-plans/"+planUuid+"/" {
+		t.Errorf("unexpected plan %v", plan)
+	}
+}
+
+func TestPreCreateCheckRejectsInvalidPlanOrLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		planName string
+		limits   map[string]int
+		expected string
+	}{
+		{"archived plan", "legacy", nil, "available plans: basic"},
+		{"unknown component", "", map[string]int{"storage": 10}, "available components: cores"},
+		{"limit too high", "", map[string]int{"cores": 32}, "must be at most 16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeWaldur(t)
+			d := newTestDriver(t, f)
+			setPlanOffering(f, d)
+			d.PlanName = tt.planName
+			d.Limits = tt.limits
+
+			err := d.PreCreateCheck()
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("expected error containing %q, got %v", tt.expected, err)
+			}
+			if _, err := os.Stat(d.GetSSHKeyPath()); !os.IsNotExist(err) {
+				t.Error("SSH key was generated despite invalid configuration")
+			}
+		})
+	}
+}
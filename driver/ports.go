@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+	"gopkg.in/yaml.v3"
+)
+
+// PortSpec configures a network port of the instance, either on a subnet or by attaching an existing port
+type PortSpec struct {
+	// Subnet is the UUID of the subnet in Waldur the port is created on
+	Subnet string `json:"subnet,omitempty" yaml:"subnet"`
+	// Port is the UUID of an existing port in Waldur attached to the instance
+	Port string `json:"port,omitempty" yaml:"port"`
+	// FixedIps are the IP addresses requested on the subnet
+	FixedIps []string `json:"fixed_ips,omitempty" yaml:"fixed_ips"`
+	// PortSecurity is only parsed to reject it: the port request of Waldur's instance order
+	// has no port security toggle, so it would otherwise be ignored silently
+	PortSecurity *bool `json:"port_security,omitempty" yaml:"port_security"`
+}
+
+// parsePorts parses a JSON or YAML list of port specifications
+func parsePorts(value string) ([]PortSpec, error) {
+	ports := []PortSpec{}
+	if strings.TrimSpace(value) == "" {
+		return ports, nil
+	}
+	if err := yaml.Unmarshal([]byte(value), &ports); err != nil {
+		return nil, fmt.Errorf("invalid ports, expected a JSON or YAML list: %w", err)
+	}
+
+	for i, port := range ports {
+		if (port.Subnet == "") == (port.Port == "") {
+			return nil, fmt.Errorf("invalid port %d, expected either a subnet or a port", i+1)
+		}
+		for _, id := range []string{port.Subnet, port.Port} {
+			if _, err := uuid.Parse(id); id != "" && err != nil {
+				return nil, fmt.Errorf("invalid port %d, %q is not a UUID", i+1, id)
+			}
+		}
+		if port.PortSecurity != nil {
+			return nil, fmt.Errorf("invalid port %d, Waldur does not support port_security when creating instances", i+1)
+		}
+		if len(port.FixedIps) > 0 && port.Subnet == "" {
+			return nil, fmt.Errorf("invalid port %d, fixed IPs require a subnet", i+1)
+		}
+		for _, ip := range port.FixedIps {
+			if net.ParseIP(ip) == nil {
+				return nil, fmt.Errorf("invalid port %d, %q is not an IP address", i+1, ip)
+			}
+		}
+	}
+	return ports, nil
+}
+
+// portSpecs returns the configured ports, or a port on each of the configured subnets
+func (d *Driver) portSpecs() []PortSpec {
+	if len(d.Ports) > 0 {
+		return d.Ports
+	}
+	ports := make([]PortSpec, len(d.SubnetUuids))
+	for i, subnet := range d.SubnetUuids {
+		ports[i] = PortSpec{Subnet: subnet}
+	}
+	return ports
+}
+
+// getSubnetBackendId returns the OpenStack ID of the subnet, which fixed IPs refer to
+func (d *Driver) getSubnetBackendId(client WaldurAPI, subnet string) (string, error) {
+	subnetUuid, err := uuid.Parse(subnet)
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	resp, err := client.OpenstackSubnetsRetrieveWithResponse(ctx, subnetUuid, &waldurclient.OpenstackSubnetsRetrieveParams{})
+	if err != nil {
+		log.Errorf("Error calling subnet retrieval API: %v", err)
+		return "", err
+	}
+	if resp.StatusCode() != 200 {
+		return "", d.apiError("subnet retrieval", "", resp.StatusCode(), resp.Body)
+	}
+	if resp.JSON200.BackendId == nil || *resp.JSON200.BackendId == "" {
+		return "", fmt.Errorf("subnet %s has no backend ID yet", subnet)
+	}
+	return *resp.JSON200.BackendId, nil
+}
+
+// buildPorts returns the port requests of the order
+func (d *Driver) buildPorts(client WaldurAPI) ([]waldurclient.OpenStackCreateInstancePortRequest, error) {
+	specs := d.portSpecs()
+	ports := make([]waldurclient.OpenStackCreateInstancePortRequest, len(specs))
+	backendIds := map[string]string{}
+	for i, spec := range specs {
+		if spec.Port != "" {
+			portUri := fmt.Sprintf("%s/api/openstack-ports/%s/", d.ApiUrl, spec.Port)
+			ports[i].Port = &portUri
+			continue
+		}
+
+		subnetUri := fmt.Sprintf("%s/api/openstack-subnets/%s/", d.ApiUrl, spec.Subnet)
+		ports[i].Subnet = &subnetUri
+		if len(spec.FixedIps) == 0 {
+			continue
+		}
+		backendId, ok := backendIds[spec.Subnet]
+		if !ok {
+			var err error
+			backendId, err = d.getSubnetBackendId(client, spec.Subnet)
+			if err != nil {
+				return nil, err
+			}
+			backendIds[spec.Subnet] = backendId
+		}
+		fixedIps := make([]waldurclient.OpenStackFixedIpRequest, len(spec.FixedIps))
+		for j, ip := range spec.FixedIps {
+			fixedIps[j] = waldurclient.OpenStackFixedIpRequest{IpAddress: ip, SubnetId: backendId}
+		}
+		ports[i].FixedIps = &fixedIps
+	}
+	return ports, nil
+}
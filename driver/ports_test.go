@@ -0,0 +1,80 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParsePorts(t *testing.T) {
+	subnet := uuid.NewString()
+	port := uuid.NewString()
+	ports, err := parsePorts("- subnet: " + subnet + "\n  fixed_ips: [10.0.0.5]\n- port: " + port + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 2 || ports[0].Subnet != subnet || ports[0].FixedIps[0] != "10.0.0.5" || ports[1].Port != port {
+		t.Errorf("unexpected ports %+v", ports)
+	}
+
+	for value, expected := range map[string]string{
+		`[{"subnet": "` + subnet + `", "port": "` + port + `"}]`: "either a subnet or a port",
+		`[{}]`:                  "either a subnet or a port",
+		`[{"subnet": "net-1"}]`: "is not a UUID",
+		`[{"port": "` + port + `", "fixed_ips": ["10.0.0.5"]}]`:   "fixed IPs require a subnet",
+		`[{"subnet": "` + subnet + `", "fixed_ips": ["10.0.0"]}]`: "is not an IP address",
+		`{"subnet": "` + subnet + `"}`:                            "expected a JSON or YAML list",
+		`[{"subnet": "` + subnet + `", "port_security": false}]`:  "does not support port_security",
+	} {
+		if _, err := parsePorts(value); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected %q error, got %v", value, expected, err)
+		}
+	}
+}
+
+func TestCreateWithPorts(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	subnet := uuid.NewString()
+	port := uuid.NewString()
+	f.SetObject("openstack-subnets", subnet, map[string]any{"uuid": subnet, "backend_id": "os-subnet-1"})
+	d.SubnetUuids = []string{}
+	d.Ports = []PortSpec{
+		{Subnet: subnet, FixedIps: []string{"10.0.0.5", "10.0.0.6"}},
+		{Port: port},
+	}
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	ports := f.Orders[0]["attributes"].(map[string]any)["ports"].([]any)
+	if len(ports) != 2 {
+		t.Fatalf("expected 2 ports, got %v", ports)
+	}
+	first := ports[0].(map[string]any)
+	if first["subnet"] != f.URL()+"/api/openstack-subnets/"+subnet+"/" {
+		t.Errorf("unexpected first port %v", first)
+	}
+	fixedIps := first["fixed_ips"].([]any)
+	if len(fixedIps) != 2 || fixedIps[1].(map[string]any)["ip_address"] != "10.0.0.6" || fixedIps[1].(map[string]any)["subnet_id"] != "os-subnet-1" {
+		t.Errorf("unexpected fixed IPs %v", fixedIps)
+	}
+	second := ports[1].(map[string]any)
+	if second["port"] != f.URL()+"/api/openstack-ports/"+port+"/" || second["subnet"] != nil {
+		t.Errorf("unexpected second port %v", second)
+	}
+}
+
+func TestCreateWithFixedIpOnUnknownSubnet(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.Ports = []PortSpec{{Subnet: uuid.NewString(), FixedIps: []string{"10.0.0.5"}}}
+
+	if err := d.Create(); err == nil || !strings.Contains(err.Error(), "subnet retrieval") {
+		t.Fatalf("expected subnet retrieval error, got %v", err)
+	}
+	if len(f.Orders) != 0 {
+		t.Error("order was submitted despite the unknown subnet")
+	}
+}
@@ -0,0 +1,122 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Stop modes select how Stop powers off the instance and which action Start uses to bring it back
+const (
+	// stopModeStop powers the instance off, it keeps consuming the flavor quota
+	stopModeStop = "stop"
+	// stopModeShelve shelves the instance, releasing its compute quota
+	stopModeShelve = "shelve"
+	// stopModeSuspend suspends the instance to disk
+	stopModeSuspend = "suspend"
+)
+
+// validateStopMode checks the configured stop mode, an empty mode defaults to stop
+func validateStopMode(mode string) error {
+	switch mode {
+	case "", stopModeStop, stopModeShelve, stopModeSuspend:
+		return nil
+	}
+	return fmt.Errorf("invalid stop mode %q, expected %s, %s or %s", mode, stopModeStop, stopModeShelve, stopModeSuspend)
+}
+
+// stopOperation returns the operation stopping the instance in the given mode
+func stopOperation(mode string) operation {
+	switch mode {
+	case stopModeShelve:
+		return operation{
+			name:           "instance shelve",
+			onInstance:     true,
+			expectedStatus: 202,
+			waitFor:        []string{"SHELVED", "SHELVED_OFFLOADED"},
+			call: func(ctx context.Context, client WaldurAPI, id uuid.UUID) (int, []byte, error) {
+				resp, err := client.OpenstackInstancesShelveWithResponse(ctx, id)
+				if err != nil {
+					return 0, nil, err
+				}
+				return resp.StatusCode(), resp.Body, nil
+			},
+		}
+	case stopModeSuspend:
+		return operation{
+			name:           "instance suspend",
+			onInstance:     true,
+			expectedStatus: 202,
+			waitFor:        []string{"SUSPENDED"},
+			call: func(ctx context.Context, client WaldurAPI, id uuid.UUID) (int, []byte, error) {
+				resp, err := client.OpenstackInstancesSuspendWithResponse(ctx, id)
+				if err != nil {
+					return 0, nil, err
+				}
+				return resp.StatusCode(), resp.Body, nil
+			},
+		}
+	}
+	return operation{
+		name:           "instance stop",
+		onInstance:     true,
+		expectedStatus: 202,
+		waitFor:        []string{"SHUTOFF", "STOPPED"},
+		call: func(ctx context.Context, client WaldurAPI, id uuid.UUID) (int, []byte, error) {
+			resp, err := client.OpenstackInstancesStopWithResponse(ctx, id)
+			if err != nil {
+				return 0, nil, err
+			}
+			return resp.StatusCode(), resp.Body, nil
+		},
+	}
+}
+
+// startOperation returns the operation bringing the instance back from its runtime state,
+// so that instances stopped in another mode than the configured one still start
+func startOperation(runtimeState string) operation {
+	switch runtimeState {
+	case "SHELVED", "SHELVED_OFFLOADED":
+		return operation{
+			name:           "instance unshelve",
+			onInstance:     true,
+			expectedStatus: 202,
+			waitFor:        []string{"ACTIVE"},
+			call: func(ctx context.Context, client WaldurAPI, id uuid.UUID) (int, []byte, error) {
+				resp, err := client.OpenstackInstancesUnshelveWithResponse(ctx, id)
+				if err != nil {
+					return 0, nil, err
+				}
+				return resp.StatusCode(), resp.Body, nil
+			},
+		}
+	case "SUSPENDED":
+		return operation{
+			name:           "instance resume",
+			onInstance:     true,
+			expectedStatus: 202,
+			waitFor:        []string{"ACTIVE"},
+			call: func(ctx context.Context, client WaldurAPI, id uuid.UUID) (int, []byte, error) {
+				resp, err := client.OpenstackInstancesResumeWithResponse(ctx, id)
+				if err != nil {
+					return 0, nil, err
+				}
+				return resp.StatusCode(), resp.Body, nil
+			},
+		}
+	}
+	return operation{
+		name:           "instance start",
+		onInstance:     true,
+		expectedStatus: 202,
+		waitFor:        []string{"ACTIVE"},
+		call: func(ctx context.Context, client WaldurAPI, id uuid.UUID) (int, []byte, error) {
+			resp, err := client.OpenstackInstancesStartWithResponse(ctx, id)
+			if err != nil {
+				return 0, nil, err
+			}
+			return resp.StatusCode(), resp.Body, nil
+		},
+	}
+}
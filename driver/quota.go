@@ -0,0 +1,230 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// quotaTitles maps Waldur quota names to the names shown to the user
+var quotaTitles = map[string]string{
+	"instances":            "instances",
+	"vcpu":                 "vCPU",
+	"ram":                  "RAM (MB)",
+	"storage":              "volume storage (MB)",
+	"volumes":              "volumes",
+	"volumes_size":         "volume storage (MB)",
+	"snapshots":            "snapshots",
+	"floating_ip_count":    "floating IPs",
+	"security_group_count": "security groups",
+	"port_count":           "ports",
+}
+
+// quotaMessagePattern matches Waldur quota validation messages, e.g.
+// "vcpu quota limit: 20, requires 24 (Tenant: dev)"
+var quotaMessagePattern = regexp.MustCompile(`([\w.-]+) quota limit: (-?[\d.]+), requires (-?[\d.]+)(?: \(([^)]*)\))?`)
+
+// QuotaShortage describes a quota which cannot accommodate the requested resources
+type QuotaShortage struct {
+	// Quota is the Waldur quota name, e.g. "vcpu"
+	Quota string
+	// Scope names the quota holder, e.g. "tenant dev"
+	Scope    string
+	Limit    float64
+	Required float64
+}
+
+// Missing returns how much the quota limit falls short of the required usage
+func (s QuotaShortage) Missing() float64 {
+	return s.Required - s.Limit
+}
+
+func (s QuotaShortage) String() string {
+	title, ok := quotaTitles[s.Quota]
+	if !ok {
+		title = s.Quota
+	}
+	msg := title + " quota"
+	if s.Scope != "" {
+		msg += " of " + s.Scope
+	}
+	return fmt.Sprintf("%s is short by %s (limit %s, required %s)", msg, formatQuota(s.Missing()), formatQuota(s.Limit), formatQuota(s.Required))
+}
+
+// QuotaError reports quotas which are too low for the requested instance
+type QuotaError struct {
+	Shortages []QuotaShortage
+	// Err is the underlying Waldur error, nil if the shortage was found by the pre-check
+	Err error
+}
+
+func (e *QuotaError) Error() string {
+	messages := make([]string, len(e.Shortages))
+	for i, shortage := range e.Shortages {
+		messages[i] = shortage.String()
+	}
+	return "insufficient quota: " + strings.Join(messages, "; ")
+}
+
+func (e *QuotaError) Unwrap() error {
+	return e.Err
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+func formatQuota(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// parseQuotaShortages extracts quota shortages from Waldur error messages
+func parseQuotaShortages(messages []string) []QuotaShortage {
+	shortages := []QuotaShortage{}
+	for _, message := range messages {
+		for _, match := range quotaMessagePattern.FindAllStringSubmatch(message, -1) {
+			limit, err := strconv.ParseFloat(match[2], 64)
+			if err != nil {
+				continue
+			}
+			required, err := strconv.ParseFloat(match[3], 64)
+			if err != nil {
+				continue
+			}
+			shortages = append(shortages, QuotaShortage{
+				Quota:    match[1],
+				Scope:    strings.TrimSpace(match[4]),
+				Limit:    limit,
+				Required: required,
+			})
+		}
+	}
+	return shortages
+}
+
+// asQuotaError translates Waldur quota validation errors into a *QuotaError,
+// any other error is returned unchanged
+func asQuotaError(err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	shortages := parseQuotaShortages(apiErr.Messages())
+	if len(shortages) == 0 {
+		return err
+	}
+	return &QuotaError{Shortages: shortages, Err: err}
+}
+
+// requiredQuotas returns the quota usage a new instance with the given flavor adds
+func (d *Driver) requiredQuotas(flavor *waldurclient.OpenStackFlavor) map[string]float64 {
+	required := map[string]float64{
+		"instances": 1,
+		"volumes":   1,
+		"storage":   float64(d.SystemVolumeSize * 1024),
+	}
+	if flavor.Cores != nil {
+		required["vcpu"] = float64(*flavor.Cores)
+	}
+	if flavor.Ram != nil {
+		required["ram"] = float64(*flavor.Ram)
+	}
+	return required
+}
+
+// findShortages compares quotas against the required usage, quotas without a limit are skipped
+func findShortages(scope string, quotas *[]waldurclient.Quota, required map[string]float64) []QuotaShortage {
+	shortages := []QuotaShortage{}
+	if quotas == nil {
+		return shortages
+	}
+	for _, quota := range *quotas {
+		if quota.Name == nil || quota.Limit == nil || *quota.Limit < 0 {
+			continue
+		}
+		amount, ok := required[*quota.Name]
+		if !ok {
+			continue
+		}
+		usage := 0.0
+		if quota.Usage != nil {
+			usage = float64(*quota.Usage)
+		}
+		limit := float64(*quota.Limit)
+		if usage+amount > limit {
+			shortages = append(shortages, QuotaShortage{
+				Quota:    *quota.Name,
+				Scope:    scope,
+				Limit:    limit,
+				Required: usage + amount,
+			})
+		}
+	}
+	return shortages
+}
+
+// checkQuotas verifies that the tenant and project quotas can accommodate
+// the configured flavor and volume sizes
+func (d *Driver) checkQuotas(client WaldurAPI) error {
+	ctx := context.Background()
+
+	flavor, err := d.getWaldurFlavor(client, d.FlavorUuid)
+	if err != nil {
+		return err
+	}
+	required := d.requiredQuotas(flavor)
+	shortages := []QuotaShortage{}
+
+	tenantUuid, err := d.getTenantUuid(client)
+	if err != nil {
+		return err
+	}
+	tenantResp, err := client.OpenstackTenantsRetrieveWithResponse(ctx, tenantUuid, &waldurclient.OpenstackTenantsRetrieveParams{})
+	if err != nil {
+		log.Errorf("Error calling tenant retrieval API: %v", err)
+		return err
+	}
+	if tenantResp.StatusCode() != 200 {
+		return d.apiError("tenant retrieval", "", tenantResp.StatusCode(), tenantResp.Body)
+	}
+	tenantScope := "tenant " + tenantUuid.String()
+	if tenantResp.JSON200.Name != nil {
+		tenantScope = "tenant " + *tenantResp.JSON200.Name
+	}
+	shortages = append(shortages, findShortages(tenantScope, tenantResp.JSON200.Quotas, required)...)
+
+	projectUuid, err := uuid.Parse(d.ProjectUuid)
+	if err != nil {
+		log.Errorf("Error converting project UUID string to UUID object: %s", err)
+		return err
+	}
+	projectResp, err := client.ProjectsRetrieveWithResponse(ctx, projectUuid, &waldurclient.ProjectsRetrieveParams{})
+	if err != nil {
+		log.Errorf("Error calling project retrieval API: %v", err)
+		return err
+	}
+	if projectResp.StatusCode() != 200 {
+		return d.apiError("project retrieval", "", projectResp.StatusCode(), projectResp.Body)
+	}
+	projectScope := "project " + d.ProjectUuid
+	if projectResp.JSON200.Name != nil {
+		projectScope = "project " + *projectResp.JSON200.Name
+	}
+	shortages = append(shortages, findShortages(projectScope, projectResp.JSON200.Quotas, required)...)
+
+	if len(shortages) > 0 {
+		quotaErr := &QuotaError{Shortages: shortages}
+		log.Errorf("Quota check failed for instance %s: %s", d.GetMachineName(), quotaErr)
+		return quotaErr
+	}
+
+	log.Infof("Quotas are sufficient for instance %s", d.GetMachineName())
+	return nil
+}
@@ -0,0 +1,56 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseQuotaShortages(t *testing.T) {
+	shortages := parseQuotaShortages([]string{
+		"One or more quotas were exceeded: vcpu quota limit: 20, requires 24 (Tenant: dev);ram quota limit: 1024.0, requires 4096.0",
+	})
+	if len(shortages) != 2 {
+		t.Fatalf("expected 2 shortages, got %v", shortages)
+	}
+	if shortages[0].Quota != "vcpu" || shortages[0].Scope != "Tenant: dev" || shortages[0].Missing() != 4 {
+		t.Errorf("unexpected vcpu shortage %+v", shortages[0])
+	}
+	if shortages[1].String() != "RAM (MB) quota is short by 3072 (limit 1024, required 4096)" {
+		t.Errorf("unexpected ram shortage %q", shortages[1].String())
+	}
+}
+
+func TestCheckQuotas(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.CheckQuotas = true
+	tenantUuid := uuid.NewString()
+	f.SetObject("openstack-flavors", d.FlavorUuid, map[string]any{"name": "m1.large", "cores": 8, "ram": 16384})
+	f.SetObject("marketplace-public-offerings", d.OfferingUuid, map[string]any{"scope_uuid": tenantUuid})
+	f.SetObject("openstack-tenants", tenantUuid, map[string]any{
+		"name": "dev",
+		"quotas": []map[string]any{
+			{"name": "vcpu", "limit": 20, "usage": 16},
+			{"name": "ram", "limit": 65536, "usage": 1024},
+			{"name": "storage", "limit": -1, "usage": 1000000},
+		},
+	})
+	f.SetObject("projects", d.ProjectUuid, map[string]any{"name": "k8s"})
+
+	err := d.PreCreateCheck()
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	expected := "insufficient quota: vCPU quota of tenant dev is short by 4 (limit 20, required 24)"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+
+	d.FlavorUuid = uuid.NewString()
+	f.SetObject("openstack-flavors", d.FlavorUuid, map[string]any{"name": "m1.small", "cores": 2, "ram": 2048})
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatalf("expected quotas to suffice, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// getWaldurFlavor fetches the flavor with the given UUID
+func (d *Driver) getWaldurFlavor(client WaldurAPI, flavor string) (*waldurclient.OpenStackFlavor, error) {
+	flavorUuid, err := uuid.Parse(flavor)
+	if err != nil {
+		log.Errorf("Error converting flavor UUID string to UUID object: %s", err)
+		return nil, err
+	}
+	ctx := context.Background()
+	resp, err := client.OpenstackFlavorsRetrieveWithResponse(ctx, flavorUuid, &waldurclient.OpenstackFlavorsRetrieveParams{})
+	if err != nil {
+		log.Errorf("Error calling flavor retrieval API: %v", err)
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, d.apiError("flavor retrieval", "", resp.StatusCode(), resp.Body)
+	}
+	return resp.JSON200, nil
+}
+
+// flavorDrift returns the configured flavor when the live instance runs another one, nil otherwise
+func (d *Driver) flavorDrift(client WaldurAPI, instance *waldurclient.OpenStackInstance) (*waldurclient.OpenStackFlavor, error) {
+	if d.FlavorUuid == "" || instance.FlavorName == nil {
+		return nil, nil
+	}
+	flavor, err := d.getWaldurFlavor(client, d.FlavorUuid)
+	if err != nil {
+		return nil, err
+	}
+	if flavor.Name == nil || *flavor.Name == *instance.FlavorName {
+		return nil, nil
+	}
+	return flavor, nil
+}
+
+// waitForFlavor polls the OpenStack instance until it is OK with the flavor of the given name.
+// The marketplace resource can still be OK right after the flavor change is accepted, so
+// only the instance tells when the change is done.
+func (d *Driver) waitForFlavor(client WaldurAPI, flavorName string) error {
+	deadline := time.Now().Add(operationPollTimeout)
+	for {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for instance %s to run flavor %s after %v", d.GetMachineName(), flavorName, operationPollTimeout)
+		}
+
+		instance, err := d.getWaldurInstance(client)
+		if err != nil {
+			return err
+		}
+		if instance.State != nil {
+			switch string(*instance.State) {
+			case "OK":
+				if instance.FlavorName != nil && *instance.FlavorName == flavorName {
+					return nil
+				}
+			case "Erred":
+				errMsg := ""
+				if instance.ErrorMessage != nil {
+					errMsg = *instance.ErrorMessage
+				}
+				return fmt.Errorf("flavor change of instance %s failed: %s", d.GetMachineName(), errMsg)
+			}
+		}
+
+		log.Infof("Waiting for instance %s to run flavor %s...", d.GetMachineName(), flavorName)
+		time.Sleep(operationPollInterval)
+	}
+}
+
+// changeFlavorOperation returns the operation changing the flavor of the stopped instance
+func changeFlavorOperation(flavorUri string) operation {
+	return operation{
+		name:           "instance flavor change",
+		onInstance:     true,
+		expectedStatus: 202,
+		call: func(ctx context.Context, client WaldurAPI, id uuid.UUID) (int, []byte, error) {
+			resp, err := client.OpenstackInstancesChangeFlavorWithResponse(ctx, id, waldurclient.OpenstackInstancesChangeFlavorJSONRequestBody{
+				Flavor: flavorUri,
+			})
+			if err != nil {
+				return 0, nil, err
+			}
+			return resp.StatusCode(), resp.Body, nil
+		},
+	}
+}
+
+// resize changes the flavor of the stopped instance and waits for completion
+func (d *Driver) resize(client WaldurAPI, flavor *waldurclient.OpenStackFlavor) error {
+	if flavor.Uuid == nil || flavor.Name == nil {
+		return fmt.Errorf("flavor of %s has no UUID or name", d.GetMachineName())
+	}
+	flavorUri := fmt.Sprintf("%s/api/openstack-flavors/%s/", d.ApiUrl, flavor.Uuid)
+	if flavor.Url != nil {
+		flavorUri = *flavor.Url
+	}
+	if err := d.runOperation(changeFlavorOperation(flavorUri)); err != nil {
+		return err
+	}
+	if err := d.waitForFlavor(client, *flavor.Name); err != nil {
+		return err
+	}
+	if _, err := d.waitForRuntimeState(client, operationPollInterval, operationPollTimeout, "SHUTOFF", "STOPPED"); err != nil {
+		return err
+	}
+
+	d.FlavorUuid = flavor.Uuid.String()
+	log.Infof("Instance %s now runs flavor %s", d.GetMachineName(), d.FlavorUuid)
+	return nil
+}
+
+// reconcileFlavor resizes the stopped instance when the persisted FlavorUuid differs from its live flavor
+func (d *Driver) reconcileFlavor(client WaldurAPI) error {
+	instance, err := d.getWaldurInstance(client)
+	if err != nil {
+		return err
+	}
+	flavor, err := d.flavorDrift(client, instance)
+	if err != nil || flavor == nil {
+		return err
+	}
+	log.Infof("Instance %s runs flavor %s instead of the configured %s, resizing it", d.GetMachineName(), *instance.FlavorName, *flavor.Name)
+	return d.resize(client, flavor)
+}
+
+// Resize changes the flavor of the instance in place. A running instance is stopped for
+// the resize and started again afterwards. The new flavor is persisted in FlavorUuid.
+func (d *Driver) Resize(flavorUuid string) error {
+	client, err := d.getWaldurClient()
+	if err != nil {
+		log.Errorf("Error creating Waldur client %s", err)
+		return err
+	}
+	flavor, err := d.getWaldurFlavor(client, flavorUuid)
+	if err != nil {
+		return err
+	}
+	instance, err := d.getWaldurInstance(client)
+	if err != nil {
+		return err
+	}
+	if flavor.Name != nil && instance.FlavorName != nil && *flavor.Name == *instance.FlavorName {
+		log.Infof("Instance %s already runs flavor %s", d.GetMachineName(), *flavor.Name)
+		d.FlavorUuid = flavorUuid
+		return nil
+	}
+
+	running := instance.RuntimeState != nil && *instance.RuntimeState == "ACTIVE"
+	if running {
+		if err := d.runOperation(stopOperation(stopModeStop)); err != nil {
+			return err
+		}
+	}
+	if err := d.resize(client, flavor); err != nil {
+		return err
+	}
+	if running {
+		return d.runOperation(startOperation("SHUTOFF"))
+	}
+	return nil
+}
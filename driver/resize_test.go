@@ -0,0 +1,96 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// addFlavor serves a flavor with the given name and returns its UUID
+func addFlavor(f *fakeWaldur, name string) string {
+	flavorUuid := uuid.NewString()
+	f.SetObject("openstack-flavors", flavorUuid, map[string]any{
+		"uuid":  flavorUuid,
+		"url":   f.URL() + "/api/openstack-flavors/" + flavorUuid + "/",
+		"name":  name,
+		"cores": 4,
+		"ram":   8192,
+	})
+	return flavorUuid
+}
+
+func TestResizeRunningInstance(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	resource := f.AddResource("node-1", "ACTIVE")
+	resource.FlavorName = "m1.small"
+	d.ResourceUuid = resource.Uuid
+	large := addFlavor(f, "m1.large")
+
+	if err := d.Resize(large); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Actions) != 3 || f.Actions[0] != "stop" || f.Actions[1] != "change_flavor" || f.Actions[2] != "start" {
+		t.Errorf("unexpected actions %v", f.Actions)
+	}
+	if resource.FlavorName != "m1.large" || resource.RuntimeState != "ACTIVE" || resource.State != "OK" {
+		t.Errorf("unexpected resource %+v", resource)
+	}
+	if d.FlavorUuid != large {
+		t.Errorf("new flavor was not persisted, got %s", d.FlavorUuid)
+	}
+}
+
+func TestResizeToCurrentFlavor(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	resource := f.AddResource("node-1", "ACTIVE")
+	resource.FlavorName = "m1.small"
+	d.ResourceUuid = resource.Uuid
+
+	if err := d.Resize(addFlavor(f, "m1.small")); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Actions) != 0 {
+		t.Errorf("unexpected actions %v", f.Actions)
+	}
+}
+
+func TestStartAppliesFlavorDrift(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	resource := f.AddResource("node-1", "SHUTOFF")
+	resource.FlavorName = "m1.small"
+	d.ResourceUuid = resource.Uuid
+	d.FlavorUuid = addFlavor(f, "m1.large")
+
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Actions) != 2 || f.Actions[0] != "change_flavor" || f.Actions[1] != "start" {
+		t.Errorf("unexpected actions %v", f.Actions)
+	}
+	if resource.FlavorName != "m1.large" || resource.RuntimeState != "ACTIVE" {
+		t.Errorf("unexpected resource %+v", resource)
+	}
+}
+
+func TestResizeErred(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	resource := f.AddResource("node-1", "SHUTOFF")
+	resource.FlavorName = "m1.small"
+	resource.FlavorError = "No valid host was found."
+	d.ResourceUuid = resource.Uuid
+	small := uuid.NewString()
+	d.FlavorUuid = small
+
+	err := d.Resize(addFlavor(f, "m1.large"))
+	if err == nil || !strings.Contains(err.Error(), "No valid host was found.") {
+		t.Fatalf("expected the flavor change error, got %v", err)
+	}
+	if d.FlavorUuid != small {
+		t.Errorf("the failed flavor was persisted: %s", d.FlavorUuid)
+	}
+}
@@ -0,0 +1,306 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// securityGroupRule is an ingress rule of the security group created by the driver
+type securityGroupRule struct {
+	Ethertype   string `json:"ethertype"`
+	Direction   string `json:"direction"`
+	Protocol    string `json:"protocol"`
+	FromPort    int    `json:"from_port"`
+	ToPort      int    `json:"to_port"`
+	Cidr        string `json:"cidr"`
+	Description string `json:"description"`
+}
+
+// rancherPorts lists the ports RKE2, K3s and Docker based Rancher nodes need to reach each other
+var rancherPorts = []struct {
+	protocol    string
+	from, to    int
+	description string
+}{
+	{"tcp", 22, 22, "SSH provisioning"},
+	{"tcp", 2376, 2376, "Docker daemon TLS"},
+	{"tcp", 2379, 2380, "etcd"},
+	{"tcp", 6443, 6443, "Kubernetes API"},
+	{"tcp", 9345, 9345, "RKE2 supervisor API"},
+	{"tcp", 10250, 10250, "kubelet"},
+	{"udp", 8472, 8472, "Canal/Flannel VXLAN"},
+	{"tcp", 30000, 32767, "NodePort services"},
+	{"udp", 30000, 32767, "NodePort services"},
+}
+
+// rancherSecurityGroupRules returns the ingress rules for the Rancher ports opened to the CIDR
+func rancherSecurityGroupRules(cidr string) []securityGroupRule {
+	rules := make([]securityGroupRule, len(rancherPorts))
+	for i, port := range rancherPorts {
+		rules[i] = securityGroupRule{
+			Ethertype:   "IPv4",
+			Direction:   "ingress",
+			Protocol:    port.protocol,
+			FromPort:    port.from,
+			ToPort:      port.to,
+			Cidr:        cidr,
+			Description: port.description,
+		}
+	}
+	return rules
+}
+
+// validateAutoSecurityGroup checks that the auto-created security group is named after the
+// cluster, so that clusters never share it, and that the CIDR it opens the Rancher node ports
+// to is given explicitly, so that they are never exposed by default
+func validateAutoSecurityGroup(auto bool, cluster, cidr string) error {
+	if !auto {
+		return nil
+	}
+	if cluster == "" {
+		return fmt.Errorf("Waldur requires the --waldur-cluster-name option with --waldur-sec-group-auto")
+	}
+	if cidr == "" {
+		return fmt.Errorf("Waldur requires the --waldur-sec-group-cidr option with --waldur-sec-group-auto")
+	}
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return fmt.Errorf("invalid --waldur-sec-group-cidr %q: %v", cidr, err)
+	}
+	return nil
+}
+
+// autoSecurityGroupName returns the name of the security group managed for the cluster
+func (d *Driver) autoSecurityGroupName() string {
+	return "rancher-" + d.ClusterName + "-nodes"
+}
+
+// securityGroupUuids returns the configured security group UUIDs without duplicates
+func (d *Driver) securityGroupUuids() []string {
+	seen := map[string]bool{}
+	groups := []string{}
+	for _, group := range append([]string{d.SecurityGroupUuid}, d.SecurityGroupUuids...) {
+		group = strings.TrimSpace(group)
+		if group == "" || seen[group] {
+			continue
+		}
+		seen[group] = true
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// findSecurityGroups looks up the security groups of the tenant with the name, ordered by UUID
+func (d *Driver) findSecurityGroups(client WaldurAPI, tenantUuid uuid.UUID, name string) ([]waldurclient.OpenStackSecurityGroup, error) {
+	ctx := context.Background()
+	groups, err := listAll(d, "security group listing", func(page, pageSize int) (int, []byte, *[]waldurclient.OpenStackSecurityGroup, error) {
+		resp, err := client.OpenstackSecurityGroupsListWithResponse(ctx, &waldurclient.OpenstackSecurityGroupsListParams{
+			TenantUuid: &tenantUuid,
+			Name:       &name,
+			Page:       &page,
+			PageSize:   &pageSize,
+		})
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return resp.StatusCode(), resp.Body, resp.JSON200, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []waldurclient.OpenStackSecurityGroup{}
+	for _, group := range groups {
+		if group.Uuid != nil && group.Name != nil && *group.Name == name {
+			matches = append(matches, group)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Uuid.String() < matches[j].Uuid.String()
+	})
+	return matches, nil
+}
+
+// createSecurityGroup creates the security group with the Rancher port rules opened to the configured CIDR
+func (d *Driver) createSecurityGroup(client WaldurAPI, tenantUuid uuid.UUID, name string) (*waldurclient.OpenStackSecurityGroup, error) {
+	if err := validateAutoSecurityGroup(true, d.ClusterName, d.SecurityGroupCidr); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]any{
+		"name":        name,
+		"description": "Rancher node ports, managed by the Waldur node driver",
+		"rules":       rancherSecurityGroupRules(d.SecurityGroupCidr),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	resp, err := client.OpenstackTenantsCreateSecurityGroupWithBodyWithResponse(ctx, tenantUuid, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Error calling security group creation API: %v", err)
+		return nil, err
+	}
+	if resp.StatusCode() != 201 || resp.JSON201 == nil {
+		return nil, d.apiError("security group creation", "", resp.StatusCode(), resp.Body)
+	}
+
+	log.Infof("Created security group %s with Rancher port rules", name)
+	return resp.JSON201, nil
+}
+
+// waitForSecurityGroup polls the security group until it is usable
+func (d *Driver) waitForSecurityGroup(client WaldurAPI, group *waldurclient.OpenStackSecurityGroup) (*waldurclient.OpenStackSecurityGroup, error) {
+	if group.Uuid == nil {
+		return group, nil
+	}
+	ctx := context.Background()
+	name := stringOf(group.Name)
+	deadline := time.Now().Add(operationPollTimeout)
+	for group.State != nil && string(*group.State) != "OK" {
+		if string(*group.State) == "Erred" {
+			return nil, fmt.Errorf("security group %s entered error state", name)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for security group %s after %v", name, operationPollTimeout)
+		}
+		time.Sleep(operationPollInterval)
+
+		groupResp, err := client.OpenstackSecurityGroupsRetrieveWithResponse(ctx, *group.Uuid, &waldurclient.OpenstackSecurityGroupsRetrieveParams{})
+		if err != nil {
+			log.Errorf("Error calling security group retrieval API: %v", err)
+			return nil, err
+		}
+		if groupResp.StatusCode() != 200 {
+			return nil, d.apiError("security group retrieval", "", groupResp.StatusCode(), groupResp.Body)
+		}
+		group = groupResp.JSON200
+	}
+	return group, nil
+}
+
+// ensureSecurityGroup returns the cluster's security group once usable, creating it with the
+// Rancher port rules when missing. Machines of a cluster are created together and may each
+// create one, so the groups are listed again and all machines settle on the first one by
+// UUID; a losing group is deleted.
+func (d *Driver) ensureSecurityGroup(client WaldurAPI) (*waldurclient.OpenStackSecurityGroup, error) {
+	tenantUuid, err := d.getTenantUuid(client)
+	if err != nil {
+		return nil, err
+	}
+	name := d.autoSecurityGroupName()
+	groups, err := d.findSecurityGroups(client, tenantUuid, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) > 0 {
+		return d.waitForSecurityGroup(client, &groups[0])
+	}
+
+	created, err := d.createSecurityGroup(client, tenantUuid, name)
+	if err != nil {
+		return nil, err
+	}
+	groups, err = d.findSecurityGroups(client, tenantUuid, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 || created.Uuid == nil || *groups[0].Uuid == *created.Uuid {
+		return d.waitForSecurityGroup(client, created)
+	}
+
+	log.Infof("Security group %s was created concurrently, using %s", name, groups[0].Uuid)
+	resp, err := client.OpenstackSecurityGroupsDestroyWithResponse(context.Background(), *created.Uuid)
+	if err != nil {
+		log.Warnf("Failed to delete duplicate security group %s: %v", created.Uuid, err)
+	} else if code := resp.StatusCode(); code != 202 && code != 204 && code != 404 {
+		log.Warnf("Failed to delete duplicate security group %s: %v", created.Uuid, d.apiError("security group deletion", "", code, resp.Body))
+	}
+	return d.waitForSecurityGroup(client, &groups[0])
+}
+
+// resolveSecurityGroups returns the security groups of the instance: the configured
+// ones followed by the cluster's security group in auto mode, whose UUID is persisted
+func (d *Driver) resolveSecurityGroups(client WaldurAPI) ([]waldurclient.OpenStackSecurityGroupHyperlinkRequest, error) {
+	securityGroups := []waldurclient.OpenStackSecurityGroupHyperlinkRequest{}
+	for _, groupUuid := range d.securityGroupUuids() {
+		securityGroups = append(securityGroups, waldurclient.OpenStackSecurityGroupHyperlinkRequest{
+			Url: fmt.Sprintf("%s/api/openstack-security-groups/%s/", d.ApiUrl, groupUuid),
+		})
+	}
+	if !d.SecurityGroupAuto {
+		return securityGroups, nil
+	}
+
+	group, err := d.ensureSecurityGroup(client)
+	if err != nil {
+		return nil, err
+	}
+	if group.Url == nil || group.Uuid == nil {
+		return nil, fmt.Errorf("security group %s has no URL", d.autoSecurityGroupName())
+	}
+	d.SecurityGroupAutoUuid = group.Uuid.String()
+	return append(securityGroups, waldurclient.OpenStackSecurityGroupHyperlinkRequest{Url: *group.Url}), nil
+}
+
+// cleanupSecurityGroup deletes the auto-created security group once no instance of the tenant references it
+func (d *Driver) cleanupSecurityGroup(client WaldurAPI) error {
+	if !d.SecurityGroupAuto || d.SecurityGroupAutoUuid == "" {
+		return nil
+	}
+	groupUuid, err := uuid.Parse(d.SecurityGroupAutoUuid)
+	if err != nil {
+		return err
+	}
+	tenantUuid, err := d.getTenantUuid(client)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	instances, err := listAll(d, "instance listing", func(page, pageSize int) (int, []byte, *[]waldurclient.OpenStackInstance, error) {
+		resp, err := client.OpenstackInstancesListWithResponse(ctx, &waldurclient.OpenstackInstancesListParams{
+			TenantUuid: &tenantUuid,
+			Page:       &page,
+			PageSize:   &pageSize,
+		})
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return resp.StatusCode(), resp.Body, resp.JSON200, nil
+	})
+	if err != nil {
+		return err
+	}
+	name := d.autoSecurityGroupName()
+	for _, instance := range instances {
+		if instance.SecurityGroups == nil {
+			continue
+		}
+		for _, group := range *instance.SecurityGroups {
+			if (group.Url != nil && strings.Contains(*group.Url, d.SecurityGroupAutoUuid)) || (group.Name != nil && *group.Name == name) {
+				log.Infof("Security group %s is still used by other instances, keeping it", name)
+				return nil
+			}
+		}
+	}
+
+	destroyResp, err := client.OpenstackSecurityGroupsDestroyWithResponse(ctx, groupUuid)
+	if err != nil {
+		return err
+	}
+	switch destroyResp.StatusCode() {
+	case 202, 204, 404:
+		log.Infof("Deleted security group %s of cluster %s", name, d.ClusterName)
+		return nil
+	}
+	return d.apiError("security group deletion", "", destroyResp.StatusCode(), destroyResp.Body)
+}
@@ -0,0 +1,192 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSecurityGroupUuids(t *testing.T) {
+	d := NewDriver("node-1", "")
+	d.SecurityGroupUuid = "a"
+	d.SecurityGroupUuids = []string{"b", "a", " ", "c", "b"}
+	if got := fmt.Sprint(d.securityGroupUuids()); got != "[a b c]" {
+		t.Errorf("unexpected security groups %s", got)
+	}
+}
+
+func TestRancherSecurityGroupRules(t *testing.T) {
+	rules := rancherSecurityGroupRules("10.0.0.0/8")
+	ports := map[string]bool{}
+	for _, rule := range rules {
+		if rule.Cidr != "10.0.0.0/8" || rule.Direction != "ingress" {
+			t.Errorf("unexpected rule %+v", rule)
+		}
+		ports[fmt.Sprintf("%s/%d-%d", rule.Protocol, rule.FromPort, rule.ToPort)] = true
+	}
+	for _, port := range []string{"tcp/22-22", "tcp/6443-6443", "tcp/9345-9345", "tcp/2379-2380", "tcp/10250-10250", "udp/8472-8472", "tcp/30000-32767", "udp/30000-32767"} {
+		if !ports[port] {
+			t.Errorf("missing rule for %s", port)
+		}
+	}
+}
+
+func TestCreateWithMultipleSecurityGroups(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	extra := uuid.NewString()
+	d.SecurityGroupUuids = []string{extra, d.SecurityGroupUuid}
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	attributes := f.Orders[0]["attributes"].(map[string]any)
+	groups := attributes["security_groups"].([]any)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 security groups, got %v", groups)
+	}
+	for i, groupUuid := range []string{d.SecurityGroupUuid, extra} {
+		expected := fmt.Sprintf("%s/api/openstack-security-groups/%s/", f.URL(), groupUuid)
+		if url := groups[i].(map[string]any)["url"]; url != expected {
+			t.Errorf("expected security group %s, got %v", expected, url)
+		}
+	}
+}
+
+func TestValidateAutoSecurityGroup(t *testing.T) {
+	if err := validateAutoSecurityGroup(false, "", ""); err != nil {
+		t.Errorf("unexpected error without auto mode: %v", err)
+	}
+	if err := validateAutoSecurityGroup(true, "prod", "10.0.0.0/8"); err != nil {
+		t.Errorf("unexpected error for a cluster and CIDR: %v", err)
+	}
+	if err := validateAutoSecurityGroup(true, "", "10.0.0.0/8"); err == nil || !strings.Contains(err.Error(), "--waldur-cluster-name") {
+		t.Errorf("expected an error for auto mode without a cluster, got %v", err)
+	}
+	if err := validateAutoSecurityGroup(true, "prod", ""); err == nil {
+		t.Error("expected an error for auto mode without a CIDR")
+	}
+	if err := validateAutoSecurityGroup(true, "prod", "10.0.0.0"); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+}
+
+func TestAutoSecurityGroupLifecycle(t *testing.T) {
+	f := newFakeWaldur(t)
+	shortPages(t)
+	// The new group is only used once it is OK
+	f.SecurityGroupStates = []string{"CREATION_SCHEDULED", "CREATING"}
+	first := newTestDriver(t, f)
+	tenantUuid := setServerGroupTenant(f, first)
+	first.MachineName = "worker1"
+	first.ClusterName = "prod"
+	first.SecurityGroupAuto = true
+	first.SecurityGroupCidr = "10.0.0.0/8"
+
+	second := newTestDriver(t, f)
+	second.OfferingUuid = first.OfferingUuid
+	second.MachineName = "worker2"
+	second.ClusterName = "prod"
+	second.SecurityGroupAuto = true
+	second.SecurityGroupCidr = "10.0.0.0/8"
+
+	for _, d := range []*Driver{first, second} {
+		if err := os.MkdirAll(filepath.Dir(d.GetSSHKeyPath()), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Create(); err != nil {
+			t.Fatalf("Create of %s failed: %v", d.MachineName, err)
+		}
+	}
+	if len(f.SecurityGroups) != 1 {
+		t.Fatalf("expected a single security group, got %d", len(f.SecurityGroups))
+	}
+	group := f.SecurityGroups[first.SecurityGroupAutoUuid]
+	if group == nil || group.Name != "rancher-prod-nodes" || group.TenantUuid != tenantUuid || second.SecurityGroupAutoUuid != group.Uuid {
+		t.Fatalf("unexpected security group %+v", group)
+	}
+	if len(group.Rules) != len(rancherPorts) || group.Rules[0]["cidr"] != "10.0.0.0/8" {
+		t.Errorf("unexpected rules %v", group.Rules)
+	}
+	if len(group.States) != 0 {
+		t.Errorf("the security group was used before it was OK, pending states %v", group.States)
+	}
+	attributes := f.Orders[1]["attributes"].(map[string]any)
+	groups := attributes["security_groups"].([]any)
+	if len(groups) != 2 || groups[1].(map[string]any)["url"] != f.securityGroupURL(group) {
+		t.Errorf("unexpected security groups %v", groups)
+	}
+
+	// An unrelated instance fills the first page of the instance listing
+	other := f.AddResource("other", "ACTIVE")
+	f.mu.Lock()
+	delete(f.instances, other.InstanceUuid)
+	other.InstanceUuid = "00000000-0000-4000-8000-000000000001"
+	f.instances[other.InstanceUuid] = other
+	f.mu.Unlock()
+
+	if err := first.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if group.Deleted {
+		t.Fatal("security group was deleted while it is still in use")
+	}
+	if err := second.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if !group.Deleted {
+		t.Fatal("security group was not deleted with its last instance")
+	}
+}
+
+func TestAutoSecurityGroupCreatedConcurrently(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	setServerGroupTenant(f, d)
+	d.ClusterName = "prod"
+	d.SecurityGroupAuto = true
+	d.SecurityGroupCidr = "10.0.0.0/8"
+
+	// Another machine of the cluster creates the group between our listing and creation,
+	// its UUID orders first so both machines settle on it
+	competitor := &fakeSecurityGroup{Uuid: "00000000-0000-4000-8000-000000000001", Name: "rancher-prod-nodes"}
+	f.BeforeSecurityGroupCreate = func(tenantUuid, name string) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		competitor.TenantUuid = tenantUuid
+		f.SecurityGroups[competitor.Uuid] = competitor
+	}
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if d.SecurityGroupAutoUuid != competitor.Uuid {
+		t.Errorf("expected the instance in the competing group, got %s", d.SecurityGroupAutoUuid)
+	}
+	for _, group := range f.SecurityGroups {
+		if group.Uuid != competitor.Uuid && !group.Deleted {
+			t.Errorf("duplicate security group %s was not deleted", group.Uuid)
+		}
+	}
+}
+
+func TestAutoSecurityGroupErred(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	setServerGroupTenant(f, d)
+	d.ClusterName = "prod"
+	d.SecurityGroupAuto = true
+	d.SecurityGroupCidr = "10.0.0.0/8"
+	f.SecurityGroupStates = []string{"CREATING", "Erred"}
+
+	if err := d.Create(); err == nil {
+		t.Fatal("expected Create to fail for an erred security group")
+	}
+	if len(f.Orders) != 0 {
+		t.Errorf("expected no order with an erred security group, got %d", len(f.Orders))
+	}
+}
@@ -0,0 +1,219 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// autoServerGroupName returns the name of the anti-affinity server group managed for the node
+// pool, qualified by the cluster as pools of different clusters in a tenant often share names
+func (d *Driver) autoServerGroupName() string {
+	return d.ClusterName + "-" + d.NodePool + "-anti-affinity"
+}
+
+// findServerGroups lists the server groups of the tenant with the UUID or name, oldest first
+// so that concurrent machines agree on the same group when duplicates exist
+func (d *Driver) findServerGroups(client WaldurAPI, tenantUuid uuid.UUID, nameOrUuid string) ([]waldurclient.OpenStackServerGroup, error) {
+	ctx := context.Background()
+	groups, err := listAll(d, "server group listing", func(page, pageSize int) (int, []byte, *[]waldurclient.OpenStackServerGroup, error) {
+		resp, err := client.OpenstackServerGroupsListWithResponse(ctx, &waldurclient.OpenstackServerGroupsListParams{
+			TenantUuid: &tenantUuid,
+			Page:       &page,
+			PageSize:   &pageSize,
+		})
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return resp.StatusCode(), resp.Body, resp.JSON200, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []waldurclient.OpenStackServerGroup{}
+	for _, group := range groups {
+		if group.Uuid == nil {
+			continue
+		}
+		if group.Uuid.String() == nameOrUuid || (group.Name != nil && *group.Name == nameOrUuid) {
+			matches = append(matches, group)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i].Created, matches[j].Created
+		if a != nil && b != nil && !a.Equal(*b) {
+			return a.Before(*b)
+		}
+		if (a == nil) != (b == nil) {
+			return a != nil
+		}
+		return matches[i].Uuid.String() < matches[j].Uuid.String()
+	})
+	return matches, nil
+}
+
+// createServerGroup creates an anti-affinity server group in the tenant and waits until it is usable
+func (d *Driver) createServerGroup(client WaldurAPI, tenantUuid uuid.UUID, name string) (*waldurclient.OpenStackServerGroup, error) {
+	ctx := context.Background()
+	policy := waldurclient.PolicyEnum("anti-affinity")
+	resp, err := client.OpenstackTenantsCreateServerGroupWithResponse(ctx, tenantUuid, waldurclient.OpenstackTenantsCreateServerGroupJSONRequestBody{
+		Name:   name,
+		Policy: &policy,
+	})
+	if err != nil {
+		log.Errorf("Error calling server group creation API: %v", err)
+		return nil, err
+	}
+	if resp.StatusCode() != 201 {
+		return nil, d.apiError("server group creation", "", resp.StatusCode(), resp.Body)
+	}
+	log.Infof("Created anti-affinity server group %s for node pool %s", name, d.NodePool)
+	return d.waitForServerGroup(client, resp.JSON201)
+}
+
+// waitForServerGroup waits until the server group is usable
+func (d *Driver) waitForServerGroup(client WaldurAPI, group *waldurclient.OpenStackServerGroup) (*waldurclient.OpenStackServerGroup, error) {
+	if group.Uuid == nil {
+		return group, nil
+	}
+	ctx := context.Background()
+	name := stringOf(group.Name)
+	deadline := time.Now().Add(operationPollTimeout)
+	for group.State != nil && string(*group.State) != "OK" {
+		if string(*group.State) == "Erred" {
+			return nil, fmt.Errorf("server group %s entered error state", name)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for server group %s after %v", name, operationPollTimeout)
+		}
+		time.Sleep(operationPollInterval)
+
+		groupResp, err := client.OpenstackServerGroupsRetrieveWithResponse(ctx, *group.Uuid, &waldurclient.OpenstackServerGroupsRetrieveParams{})
+		if err != nil {
+			log.Errorf("Error calling server group retrieval API: %v", err)
+			return nil, err
+		}
+		if groupResp.StatusCode() != 200 {
+			return nil, d.apiError("server group retrieval", "", groupResp.StatusCode(), groupResp.Body)
+		}
+		group = groupResp.JSON200
+	}
+	return group, nil
+}
+
+// createAutoServerGroup creates the node pool's group. Machines of a pool are created together
+// and may each create one, so the groups are listed again and all machines settle on the
+// oldest one; a losing group is deleted. A machine listing right after its creation sees every
+// older group, so the machine which created the oldest group is the only one that may miss
+// the others and it keeps the group all the others settle on. Only groups created within the
+// same timestamp fall back to the UUID order and may still split the pool.
+func (d *Driver) createAutoServerGroup(client WaldurAPI, tenantUuid uuid.UUID, name string) (*waldurclient.OpenStackServerGroup, error) {
+	created, err := d.createServerGroup(client, tenantUuid, name)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := d.findServerGroups(client, tenantUuid, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 || created.Uuid == nil || *groups[0].Uuid == *created.Uuid {
+		return created, nil
+	}
+
+	log.Infof("Server group %s was created concurrently, using %s", name, groups[0].Uuid)
+	resp, err := client.OpenstackServerGroupsDestroyWithResponse(context.Background(), *created.Uuid)
+	if err != nil {
+		log.Warnf("Failed to delete duplicate server group %s: %v", created.Uuid, err)
+	} else if code := resp.StatusCode(); code != 202 && code != 204 && code != 404 {
+		log.Warnf("Failed to delete duplicate server group %s: %v", created.Uuid, d.apiError("server group deletion", "", code, resp.Body))
+	}
+	return d.waitForServerGroup(client, &groups[0])
+}
+
+// resolveServerGroup returns the URL of the server group the instance is placed in,
+// creating the node pool's anti-affinity group on first use in auto mode.
+// The group UUID is persisted so that Remove can clean up auto-created groups.
+func (d *Driver) resolveServerGroup(client WaldurAPI) (*string, error) {
+	if d.ServerGroup == "" && !d.ServerGroupAuto {
+		return nil, nil
+	}
+
+	tenantUuid, err := d.getTenantUuid(client)
+	if err != nil {
+		return nil, err
+	}
+
+	name := d.ServerGroup
+	if d.ServerGroupAuto {
+		name = d.autoServerGroupName()
+	}
+	groups, err := d.findServerGroups(client, tenantUuid, name)
+	if err != nil {
+		return nil, err
+	}
+	var group *waldurclient.OpenStackServerGroup
+	switch {
+	case len(groups) > 0:
+		group, err = d.waitForServerGroup(client, &groups[0])
+	case !d.ServerGroupAuto:
+		return nil, fmt.Errorf("server group %s not found in the tenant of offering %s", name, d.OfferingUuid)
+	default:
+		group, err = d.createAutoServerGroup(client, tenantUuid, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if group.Url == nil || group.Uuid == nil {
+		return nil, fmt.Errorf("server group %s has no URL", name)
+	}
+
+	d.ServerGroupUuid = group.Uuid.String()
+	log.Infof("Placing instance %s in server group %s", d.GetMachineName(), name)
+	return group.Url, nil
+}
+
+// cleanupServerGroup deletes the auto-created server group once it has no members left
+func (d *Driver) cleanupServerGroup(client WaldurAPI) error {
+	if !d.ServerGroupAuto || d.ServerGroupUuid == "" {
+		return nil
+	}
+	groupUuid, err := uuid.Parse(d.ServerGroupUuid)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	resp, err := client.OpenstackServerGroupsRetrieveWithResponse(ctx, groupUuid, &waldurclient.OpenstackServerGroupsRetrieveParams{})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != 200 {
+		err := d.apiError("server group retrieval", "", resp.StatusCode(), resp.Body)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if resp.JSON200.Instances != nil && len(*resp.JSON200.Instances) > 0 {
+		log.Infof("Server group %s still has %d members, keeping it", d.ServerGroupUuid, len(*resp.JSON200.Instances))
+		return nil
+	}
+
+	destroyResp, err := client.OpenstackServerGroupsDestroyWithResponse(ctx, groupUuid)
+	if err != nil {
+		return err
+	}
+	switch destroyResp.StatusCode() {
+	case 202, 204, 404:
+		log.Infof("Deleted server group %s of node pool %s", d.ServerGroupUuid, d.NodePool)
+		return nil
+	}
+	return d.apiError("server group deletion", "", destroyResp.StatusCode(), destroyResp.Body)
+}
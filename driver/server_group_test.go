@@ -0,0 +1,144 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// setServerGroupTenant serves an offering backed by a new tenant and returns the tenant UUID
+func setServerGroupTenant(f *fakeWaldur, d *Driver) string {
+	tenantUuid := uuid.NewString()
+	f.SetObject("marketplace-public-offerings", d.OfferingUuid, map[string]any{"scope_uuid": tenantUuid})
+	return tenantUuid
+}
+
+func TestCreateWithExistingServerGroup(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	group := f.AddServerGroup(setServerGroupTenant(f, d), "control-plane")
+	d.ServerGroup = "control-plane"
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	attributes := f.Orders[0]["attributes"].(map[string]any)
+	if attributes["server_group"] != f.serverGroupURL(group) {
+		t.Errorf("unexpected server group %v", attributes["server_group"])
+	}
+
+	d.ServerGroup = "missing"
+	if err := d.Create(); err == nil || !strings.Contains(err.Error(), "server group missing not found") {
+		t.Errorf("expected missing server group error, got %v", err)
+	}
+}
+
+func TestAutoServerGroupLifecycle(t *testing.T) {
+	f := newFakeWaldur(t)
+	first := newTestDriver(t, f)
+	setServerGroupTenant(f, first)
+	first.MachineName = "etcd-7f9c4-xk2lp"
+	first.ClusterName = "prod"
+	first.NodePool = "etcd"
+	first.ServerGroupAuto = true
+
+	second := newTestDriver(t, f)
+	second.OfferingUuid = first.OfferingUuid
+	second.MachineName = "etcd-7f9c4-q8z3m"
+	second.ClusterName = "prod"
+	second.NodePool = "etcd"
+	second.ServerGroupAuto = true
+
+	for _, d := range []*Driver{first, second} {
+		if err := os.MkdirAll(filepath.Dir(d.GetSSHKeyPath()), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Create(); err != nil {
+			t.Fatalf("Create of %s failed: %v", d.MachineName, err)
+		}
+	}
+	if len(f.ServerGroups) != 1 {
+		t.Fatalf("expected a single server group, got %d", len(f.ServerGroups))
+	}
+	group := f.ServerGroups[first.ServerGroupUuid]
+	if group == nil || group.Name != "prod-etcd-anti-affinity" || group.Policy != "anti-affinity" || second.ServerGroupUuid != group.Uuid {
+		t.Fatalf("unexpected server group %+v", group)
+	}
+
+	if err := first.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if group.Deleted {
+		t.Fatal("server group was deleted while it still has members")
+	}
+	if err := second.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if !group.Deleted {
+		t.Fatal("server group was not deleted with its last member")
+	}
+}
+
+func TestAutoServerGroupCreatedConcurrently(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	setServerGroupTenant(f, d)
+	d.ClusterName = "prod"
+	d.NodePool = "workers"
+	d.ServerGroupAuto = true
+
+	// Another machine of the pool creates its group between our listing and creation,
+	// it is older so both machines settle on it whatever the UUIDs
+	competitor := &fakeServerGroup{Uuid: "ffffffff-ffff-4fff-bfff-ffffffffffff", Name: "prod-workers-anti-affinity", Policy: "anti-affinity", Created: time.Now().Add(-time.Second)}
+	f.BeforeServerGroupCreate = func(tenantUuid, name string) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		competitor.TenantUuid = tenantUuid
+		f.ServerGroups[competitor.Uuid] = competitor
+	}
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if d.ServerGroupUuid != competitor.Uuid {
+		t.Errorf("expected the instance in the competing group, got %s", d.ServerGroupUuid)
+	}
+	for _, group := range f.ServerGroups {
+		if group.Uuid != competitor.Uuid && !group.Deleted {
+			t.Errorf("duplicate server group %s was not deleted", group.Uuid)
+		}
+	}
+}
+
+func TestAutoServerGroupKeptAgainstLaterCreation(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	setServerGroupTenant(f, d)
+	d.ClusterName = "prod"
+	d.NodePool = "workers"
+	d.ServerGroupAuto = true
+
+	// Another machine creates its group after ours with a lower UUID: it will see ours as
+	// older and give up its own, so ours must be kept rather than split the pool
+	competitor := &fakeServerGroup{Uuid: "00000000-0000-4000-8000-000000000001", Name: "prod-workers-anti-affinity", Policy: "anti-affinity", Created: time.Now().Add(time.Second)}
+	f.BeforeServerGroupCreate = func(tenantUuid, name string) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		competitor.TenantUuid = tenantUuid
+		f.ServerGroups[competitor.Uuid] = competitor
+	}
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if d.ServerGroupUuid == competitor.Uuid || f.ServerGroups[d.ServerGroupUuid].Deleted {
+		t.Errorf("expected the instance in its own older group, got %s", d.ServerGroupUuid)
+	}
+	if competitor.Deleted {
+		t.Error("the competing group is deleted by its own machine, not by us")
+	}
+}
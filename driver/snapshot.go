@@ -0,0 +1,258 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// Snapshot modes select how Remove preserves the volumes of the instance
+const (
+	// snapshotModeSnapshot creates a snapshot of every volume
+	snapshotModeSnapshot = "snapshot"
+	// snapshotModeBackup creates a Waldur backup of the instance
+	snapshotModeBackup = "backup"
+)
+
+// removalManifestDir is the directory in the store path the removal manifests are written to,
+// outside of the machine directory which is deleted along with the machine
+const removalManifestDir = "waldur-removals"
+
+// validateSnapshotMode checks the configured snapshot mode, an empty mode disables snapshots
+func validateSnapshotMode(mode string) error {
+	switch mode {
+	case "", snapshotModeSnapshot, snapshotModeBackup:
+		return nil
+	}
+	return fmt.Errorf("invalid snapshot mode %q, expected %s or %s", mode, snapshotModeSnapshot, snapshotModeBackup)
+}
+
+// snapshotRecord describes a snapshot or backup taken before removal
+type snapshotRecord struct {
+	Kind       string `json:"kind"`
+	Uuid       string `json:"uuid"`
+	Name       string `json:"name"`
+	VolumeUuid string `json:"volume_uuid,omitempty"`
+}
+
+// retainedVolumesCleanup tells how to release the volumes kept by a removal with snapshots
+const retainedVolumesCleanup = "The volumes in retained_volumes were kept because the snapshots depend on them. " +
+	"Once the snapshots are no longer needed, delete them and then the volumes, e.g. with " +
+	"DELETE /api/openstack-snapshots/<uuid>/ or /api/openstack-backups/<uuid>/ followed by DELETE /api/openstack-volumes/<uuid>/."
+
+// removalManifest records what was preserved of a removed machine and the volumes left behind for it
+type removalManifest struct {
+	Machine         string           `json:"machine"`
+	ResourceUuid    string           `json:"resource_uuid"`
+	InstanceUuid    string           `json:"instance_uuid"`
+	Mode            string           `json:"mode"`
+	CreatedAt       time.Time        `json:"created_at"`
+	Snapshots       []snapshotRecord `json:"snapshots"`
+	RetainedVolumes []string         `json:"retained_volumes"`
+	Cleanup         string           `json:"cleanup"`
+}
+
+// waitUntilOK polls an object until its state is OK, failing when it becomes erred
+func (d *Driver) waitUntilOK(what string, poll func() (state, errMsg string, err error)) error {
+	deadline := time.Now().Add(operationPollTimeout)
+	for {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s after %v", what, operationPollTimeout)
+		}
+		state, errMsg, err := poll()
+		if err != nil {
+			return err
+		}
+		switch state {
+		case "OK":
+			return nil
+		case "Erred":
+			return fmt.Errorf("%s failed: %s", what, errMsg)
+		}
+
+		log.Infof("Waiting for %s, state: %s", what, state)
+		time.Sleep(operationPollInterval)
+	}
+}
+
+// snapshotVolume creates a snapshot of the volume and waits until it is available
+func (d *Driver) snapshotVolume(client WaldurAPI, volume waldurclient.OpenStackNestedVolume, name string) (*snapshotRecord, error) {
+	ctx := context.Background()
+	description := fmt.Sprintf("Snapshot of %s taken before its removal", d.GetMachineName())
+	resp, err := client.OpenstackVolumesSnapshotWithResponse(ctx, *volume.Uuid, waldurclient.OpenstackVolumesSnapshotJSONRequestBody{
+		Name:        name,
+		Description: &description,
+	})
+	if err != nil {
+		log.Errorf("Error calling volume snapshot API: %v", err)
+		return nil, err
+	}
+	if resp.StatusCode() != 201 {
+		return nil, d.apiError("volume snapshot", d.ResourceUuid, resp.StatusCode(), resp.Body)
+	}
+	if resp.JSON201.Uuid == nil {
+		return nil, fmt.Errorf("snapshot %s of %s has no UUID", name, d.GetMachineName())
+	}
+	snapshotUuid := *resp.JSON201.Uuid
+
+	err = d.waitUntilOK("snapshot "+name, func() (string, string, error) {
+		resp, err := client.OpenstackSnapshotsRetrieveWithResponse(ctx, snapshotUuid, &waldurclient.OpenstackSnapshotsRetrieveParams{})
+		if err != nil {
+			return "", "", err
+		}
+		if resp.StatusCode() != 200 {
+			return "", "", d.apiError("snapshot retrieval", d.ResourceUuid, resp.StatusCode(), resp.Body)
+		}
+		return stateOf(resp.JSON200.State), stringOf(resp.JSON200.ErrorMessage), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotRecord{Kind: snapshotModeSnapshot, Uuid: snapshotUuid.String(), Name: name, VolumeUuid: volume.Uuid.String()}, nil
+}
+
+// backupInstance creates a backup of the instance and waits until it is available
+func (d *Driver) backupInstance(client WaldurAPI, instanceUuid uuid.UUID, name string) (*snapshotRecord, error) {
+	ctx := context.Background()
+	description := fmt.Sprintf("Backup of %s taken before its removal", d.GetMachineName())
+	resp, err := client.OpenstackInstancesBackupWithResponse(ctx, instanceUuid, waldurclient.OpenstackInstancesBackupJSONRequestBody{
+		Name:        name,
+		Description: &description,
+	})
+	if err != nil {
+		log.Errorf("Error calling instance backup API: %v", err)
+		return nil, err
+	}
+	if resp.StatusCode() != 201 {
+		return nil, d.apiError("instance backup", d.ResourceUuid, resp.StatusCode(), resp.Body)
+	}
+	if resp.JSON201.Uuid == nil {
+		return nil, fmt.Errorf("backup %s of %s has no UUID", name, d.GetMachineName())
+	}
+	backupUuid := *resp.JSON201.Uuid
+
+	err = d.waitUntilOK("backup "+name, func() (string, string, error) {
+		resp, err := client.OpenstackBackupsRetrieveWithResponse(ctx, backupUuid, &waldurclient.OpenstackBackupsRetrieveParams{})
+		if err != nil {
+			return "", "", err
+		}
+		if resp.StatusCode() != 200 {
+			return "", "", d.apiError("backup retrieval", d.ResourceUuid, resp.StatusCode(), resp.Body)
+		}
+		return stateOf(resp.JSON200.State), stringOf(resp.JSON200.ErrorMessage), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotRecord{Kind: snapshotModeBackup, Uuid: backupUuid.String(), Name: name}, nil
+}
+
+// preserveVolumes snapshots or backs up the instance according to the snapshot mode and
+// writes the removal manifest. It returns nil when the instance is already gone.
+func (d *Driver) preserveVolumes(client WaldurAPI) (*removalManifest, error) {
+	instance, err := d.getWaldurInstance(client)
+	if errors.Is(err, ErrNotFound) {
+		log.Warnf("Instance %s (%s) is already gone, nothing to snapshot", d.GetMachineName(), d.ResourceUuid)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if instance.Uuid == nil {
+		return nil, fmt.Errorf("instance of %s has no UUID", d.GetMachineName())
+	}
+
+	now := time.Now().UTC()
+	prefix := fmt.Sprintf("%s-%s", d.GetMachineName(), now.Format("20060102-150405"))
+	manifest := &removalManifest{
+		Machine:      d.GetMachineName(),
+		ResourceUuid: d.ResourceUuid,
+		InstanceUuid: instance.Uuid.String(),
+		Mode:         d.SnapshotOnRemove,
+		CreatedAt:    now,
+		Snapshots:    []snapshotRecord{},
+		// Snapshots and backups depend on their source volumes in OpenStack, so the instance
+		// is terminated without its volumes
+		RetainedVolumes: []string{},
+		Cleanup:         retainedVolumesCleanup,
+	}
+	if instance.Volumes != nil {
+		for _, volume := range *instance.Volumes {
+			if volume.Uuid != nil {
+				manifest.RetainedVolumes = append(manifest.RetainedVolumes, volume.Uuid.String())
+			}
+		}
+	}
+
+	if d.SnapshotOnRemove == snapshotModeBackup {
+		record, err := d.backupInstance(client, *instance.Uuid, prefix)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Created backup %s (%s) of %s", record.Name, record.Uuid, d.GetMachineName())
+		manifest.Snapshots = append(manifest.Snapshots, *record)
+	} else if instance.Volumes != nil {
+		for i, volume := range *instance.Volumes {
+			if volume.Uuid == nil {
+				continue
+			}
+			record, err := d.snapshotVolume(client, volume, fmt.Sprintf("%s-%d", prefix, i))
+			if err != nil {
+				return nil, err
+			}
+			log.Infof("Created snapshot %s (%s) of volume %s of %s", record.Name, record.Uuid, record.VolumeUuid, d.GetMachineName())
+			manifest.Snapshots = append(manifest.Snapshots, *record)
+		}
+	}
+
+	path, err := d.writeRemovalManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Recorded the snapshots of %s in %s", d.GetMachineName(), path)
+	log.Warnf("Keeping volumes %s of %s for the snapshots, delete them along with the snapshots once no longer needed as described in %s",
+		strings.Join(manifest.RetainedVolumes, ", "), d.GetMachineName(), path)
+	return manifest, nil
+}
+
+// writeRemovalManifest stores the manifest in the removal manifest directory and returns its path
+func (d *Driver) writeRemovalManifest(manifest *removalManifest) (string, error) {
+	dir := filepath.Join(d.StorePath, removalManifestDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create removal manifest directory: %w", err)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.json", manifest.Machine, manifest.CreatedAt.Format("20060102-150405")))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write removal manifest: %w", err)
+	}
+	return path, nil
+}
+
+// stateOf returns the core state as a string, empty when unset
+func stateOf(state *waldurclient.CoreStates) string {
+	if state == nil {
+		return ""
+	}
+	return string(*state)
+}
+
+// stringOf returns the string value, empty when unset
+func stringOf(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
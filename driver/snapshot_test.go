@@ -0,0 +1,133 @@
+package driver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// readRemovalManifest loads the only removal manifest written to the driver's store path
+func readRemovalManifest(t *testing.T, d *Driver) removalManifest {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(d.StorePath, removalManifestDir, "node-1-*.json"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("expected one removal manifest, got %v (%v)", paths, err)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := removalManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestRemoveSnapshotsVolumes(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.SnapshotOnRemove = snapshotModeSnapshot
+	resource := f.AddResource("node-1", "ACTIVE")
+	system := f.AddVolume(resource, true, 20*1024, "/dev/vda")
+	data := f.AddVolume(resource, false, 10*1024, "/dev/vdb")
+	d.ResourceUuid = resource.Uuid
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(f.Actions, ",") != "snapshot,snapshot" {
+		t.Errorf("unexpected actions %v", f.Actions)
+	}
+	if len(f.Terminations) != 1 || f.Terminations[0]["delete_volumes"] != false {
+		t.Fatalf("volumes should be kept, got terminations %v", f.Terminations)
+	}
+
+	manifest := readRemovalManifest(t, d)
+	if manifest.Mode != snapshotModeSnapshot || manifest.ResourceUuid != resource.Uuid || manifest.InstanceUuid != resource.InstanceUuid {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+	if len(manifest.Snapshots) != 2 {
+		t.Fatalf("unexpected snapshots %+v", manifest.Snapshots)
+	}
+	if strings.Join(manifest.RetainedVolumes, ",") != system.Uuid+","+data.Uuid || manifest.Cleanup == "" {
+		t.Errorf("the kept volumes are not recorded for cleanup: %v, %q", manifest.RetainedVolumes, manifest.Cleanup)
+	}
+	for i, volume := range []*fakeVolume{system, data} {
+		record := manifest.Snapshots[i]
+		snapshot, ok := f.Snapshots[record.Uuid]
+		if !ok || snapshot.Source != volume.Uuid || record.VolumeUuid != volume.Uuid || snapshot.State != "OK" {
+			t.Errorf("unexpected snapshot %+v of volume %s", record, volume.Uuid)
+		}
+	}
+}
+
+func TestRemoveBacksUpInstance(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.SnapshotOnRemove = snapshotModeBackup
+	resource := f.AddResource("node-1", "ACTIVE")
+	f.AddVolume(resource, true, 20*1024, "/dev/vda")
+	d.ResourceUuid = resource.Uuid
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	manifest := readRemovalManifest(t, d)
+	if len(manifest.Snapshots) != 1 || manifest.Snapshots[0].Kind != snapshotModeBackup {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	if snapshot := f.Snapshots[manifest.Snapshots[0].Uuid]; snapshot == nil || snapshot.Source != resource.InstanceUuid {
+		t.Errorf("backup was not taken of instance %s", resource.InstanceUuid)
+	}
+	if !resource.Terminated {
+		t.Error("resource was not terminated")
+	}
+}
+
+func TestRemoveAbortsWhenSnapshotFails(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.SnapshotOnRemove = snapshotModeSnapshot
+	resource := f.AddResource("node-1", "ACTIVE")
+	f.AddVolume(resource, true, 20*1024, "/dev/vda")
+	d.ResourceUuid = resource.Uuid
+	f.Override("GET /api/openstack-snapshots/{uuid}/", 200, map[string]string{
+		"state":         "Erred",
+		"error_message": "quota exceeded",
+	})
+
+	err := d.Remove()
+	if err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Fatalf("expected the snapshot error, got %v", err)
+	}
+	if len(f.Terminations) != 0 || resource.Terminated {
+		t.Error("resource was terminated despite the failed snapshot")
+	}
+}
+
+func TestRemoveWithSnapshotsOfMissingResource(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.SnapshotOnRemove = snapshotModeSnapshot
+	d.ResourceUuid = uuid.NewString()
+
+	if err := d.Remove(); err != nil {
+		t.Fatalf("removing a missing resource should succeed, got %v", err)
+	}
+}
+
+func TestValidateSnapshotMode(t *testing.T) {
+	for _, mode := range []string{"", snapshotModeSnapshot, snapshotModeBackup} {
+		if err := validateSnapshotMode(mode); err != nil {
+			t.Errorf("mode %q: %v", mode, err)
+		}
+	}
+	if err := validateSnapshotMode("image"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
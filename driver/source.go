@@ -0,0 +1,164 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// Boot sources select what the instance is created from
+const (
+	// sourceImage orders the instance from ImageUuid
+	sourceImage = "image"
+	// sourceBackup restores the instance from an instance backup
+	sourceBackup = "backup"
+	// sourceSnapshot is rejected: Waldur restores a volume snapshot to a detached volume only,
+	// neither its instance order nor any instance action boots from a snapshot or a volume
+	sourceSnapshot = "snapshot"
+)
+
+// validateSource checks the boot source and that the UUID it needs is configured
+func validateSource(source, sourceUuid, imageUuid string) error {
+	switch source {
+	case "", sourceImage:
+		if imageUuid == "" {
+			return fmt.Errorf("Waldur requires the --waldur-image-uuid option")
+		}
+		return nil
+	case sourceBackup:
+		if sourceUuid == "" {
+			return fmt.Errorf("Waldur requires the --waldur-source-uuid option with the %s source", source)
+		}
+		if _, err := uuid.Parse(sourceUuid); err != nil {
+			return fmt.Errorf("invalid --waldur-source-uuid %q: %w", sourceUuid, err)
+		}
+		return nil
+	case sourceSnapshot:
+		return fmt.Errorf("the %s source is not supported, Waldur cannot boot an instance from a volume snapshot: use the %s source with an instance backup", source, sourceBackup)
+	}
+	return fmt.Errorf("invalid source %q, expected %s or %s", source, sourceImage, sourceBackup)
+}
+
+// validateBackupSource rejects the options a backup restoration would silently ignore:
+// the instance is created by the restoration action rather than by a marketplace order
+func (d *Driver) validateBackupSource() error {
+	unsupported := []string{}
+	if d.PlanUuid != "" || d.PlanName != "" {
+		unsupported = append(unsupported, "--waldur-plan-uuid", "--waldur-plan-name")
+	}
+	if len(d.Limits) > 0 {
+		unsupported = append(unsupported, "--waldur-limit")
+	}
+	if len(d.OrderAttributes) > 0 {
+		unsupported = append(unsupported, "--waldur-order-attributes")
+	}
+	if d.CheckQuotas {
+		unsupported = append(unsupported, "--waldur-check-quotas")
+	}
+	if d.AvailabilityZone != "" || d.AvailabilityZoneSpread {
+		unsupported = append(unsupported, "--waldur-availability-zone", "--waldur-availability-zone-spread")
+	}
+	if d.ServerGroup != "" || d.ServerGroupAuto {
+		unsupported = append(unsupported, "--waldur-server-group", "--waldur-server-group-auto")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("the %s source restores the instance without an order and does not support %s", sourceBackup, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// restoreBackup creates the instance from the backup with the driver's flavor, network and
+// user data, then waits for its marketplace resource and for the instance to become active.
+// The options the restoration action does not accept are rejected by validateBackupSource.
+func (d *Driver) restoreBackup(client WaldurAPI, flavorUri string, securityGroups []waldurclient.OpenStackSecurityGroupHyperlinkRequest, ports []waldurclient.OpenStackCreateInstancePortRequest, userData string) error {
+	backupUuid, err := uuid.Parse(d.SourceUuid)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	backupResp, err := client.OpenstackBackupsRetrieveWithResponse(ctx, backupUuid, &waldurclient.OpenstackBackupsRetrieveParams{})
+	if err != nil {
+		log.Errorf("Error calling backup retrieval API: %v", err)
+		return err
+	}
+	if backupResp.StatusCode() != 200 {
+		return d.apiError("backup retrieval", "", backupResp.StatusCode(), backupResp.Body)
+	}
+	if state := stateOf(backupResp.JSON200.State); state != "OK" {
+		return fmt.Errorf("backup %s is %s, only available backups can be restored", d.SourceUuid, state)
+	}
+
+	name := d.ResourceName
+	resp, err := client.OpenstackBackupsRestoreWithResponse(ctx, backupUuid, waldurclient.OpenstackBackupsRestoreJSONRequestBody{
+		Flavor:         flavorUri,
+		Name:           &name,
+		Ports:          &ports,
+		SecurityGroups: &securityGroups,
+		UserData:       &userData,
+	})
+	if err != nil {
+		log.Errorf("Error calling API for backup restoration: %v", err)
+		return err
+	}
+	if resp.StatusCode() != 201 {
+		return asQuotaError(d.apiError("backup restoration", "", resp.StatusCode(), resp.Body))
+	}
+	instance := resp.JSON201
+	if instance.Uuid == nil {
+		return fmt.Errorf("restoration of backup %s for %s returned no instance", d.SourceUuid, d.GetMachineName())
+	}
+	log.Infof("Successfully requested restoration of backup %s for instance %s", d.SourceUuid, d.GetMachineName())
+
+	instanceUri := fmt.Sprintf("%s/api/openstack-instances/%s/", d.ApiUrl, instance.Uuid)
+	if instance.Url != nil {
+		instanceUri = *instance.Url
+	}
+	resourceUuid, err := d.waitForInstanceResource(client, instanceUri)
+	if err != nil {
+		return err
+	}
+	d.ResourceUuid = resourceUuid.String()
+	log.Infof("Resource UUID: %s", d.ResourceUuid)
+	d.describeResource(client)
+
+	return d.waitForActive(client)
+}
+
+// waitForInstanceResource polls the Waldur API until the marketplace resource of the instance exists
+func (d *Driver) waitForInstanceResource(client WaldurAPI, instanceUri string) (uuid.UUID, error) {
+	ctx := context.Background()
+	pageSize := listPageSize
+	deadline := time.Now().Add(creationPollTimeout)
+	for {
+		if time.Now().After(deadline) {
+			return uuid.Nil, fmt.Errorf("timed out waiting for the resource of instance %s after %v", d.GetMachineName(), creationPollTimeout)
+		}
+
+		resp, err := client.MarketplaceResourcesListWithResponse(ctx, &waldurclient.MarketplaceResourcesListParams{
+			Scope:    &instanceUri,
+			PageSize: &pageSize,
+		})
+		if err != nil {
+			log.Errorf("Error calling resource listing API: %v", err)
+			return uuid.Nil, err
+		}
+		if resp.StatusCode() != 200 {
+			return uuid.Nil, d.apiError("resource listing", "", resp.StatusCode(), resp.Body)
+		}
+		if resp.JSON200 != nil {
+			for _, resource := range *resp.JSON200 {
+				if resource.Uuid != nil {
+					return *resource.Uuid, nil
+				}
+			}
+		}
+
+		log.Infof("Instance %s has no resource yet — waiting...", d.GetMachineName())
+		time.Sleep(creationPollInterval)
+	}
+}
@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCreateFromBackup(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	backup := f.AddSnapshot("node-0-backup")
+	d.Source = sourceBackup
+	d.SourceUuid = backup.Uuid
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if len(f.Orders) != 0 || len(f.Restorations) != 1 {
+		t.Fatalf("expected a single restoration, got orders %v, restorations %v", f.Orders, f.Restorations)
+	}
+	restoration := f.Restorations[0]
+	if restoration["name"] != "node-1" || restoration["flavor"] != d.ApiUrl+"/api/openstack-flavors/"+d.FlavorUuid+"/" {
+		t.Errorf("unexpected restoration %v", restoration)
+	}
+	if userData, _ := restoration["user_data"].(string); !strings.Contains(userData, "ssh_authorized_keys") {
+		t.Errorf("user data lacks the SSH key: %q", userData)
+	}
+	if f.Resource(d.ResourceUuid) == nil {
+		t.Fatalf("resource %q of the restored instance was not found", d.ResourceUuid)
+	}
+	if d.IPAddress != "192.168.42.10" {
+		t.Errorf("unexpected IP address %q", d.IPAddress)
+	}
+}
+
+func TestValidateSource(t *testing.T) {
+	tests := []struct {
+		source, sourceUuid, imageUuid string
+		valid                         bool
+	}{
+		{"", "", uuid.NewString(), true},
+		{sourceImage, "", uuid.NewString(), true},
+		{sourceImage, "", "", false},
+		{sourceBackup, uuid.NewString(), "", true},
+		{sourceBackup, "", "", false},
+		{sourceBackup, "latest", "", false},
+		{sourceSnapshot, uuid.NewString(), "", false},
+		{"volume", uuid.NewString(), "", false},
+	}
+	for _, test := range tests {
+		err := validateSource(test.source, test.sourceUuid, test.imageUuid)
+		if (err == nil) != test.valid {
+			t.Errorf("source %q with %q: unexpected result %v", test.source, test.sourceUuid, err)
+		}
+	}
+}
+
+func TestSnapshotSourceIsRejected(t *testing.T) {
+	err := validateSource(sourceSnapshot, uuid.NewString(), "")
+	if err == nil || !strings.Contains(err.Error(), "cannot boot an instance from a volume snapshot") {
+		t.Errorf("expected the snapshot source to be rejected, got %v", err)
+	}
+}
+
+func TestValidateBackupSource(t *testing.T) {
+	d := NewDriver("node-1", "")
+	d.Source = sourceBackup
+	if err := d.validateBackupSource(); err != nil {
+		t.Errorf("unexpected error without order options: %v", err)
+	}
+
+	for flag, configure := range map[string]func(d *Driver){
+		"--waldur-plan-uuid":                func(d *Driver) { d.PlanUuid = uuid.NewString() },
+		"--waldur-limit":                    func(d *Driver) { d.Limits = map[string]int{"cores": 2} },
+		"--waldur-order-attributes":         func(d *Driver) { d.OrderAttributes = map[string]any{"tags": "k8s"} },
+		"--waldur-check-quotas":             func(d *Driver) { d.CheckQuotas = true },
+		"--waldur-availability-zone-spread": func(d *Driver) { d.AvailabilityZoneSpread = true },
+		"--waldur-server-group-auto":        func(d *Driver) { d.ServerGroupAuto = true },
+	} {
+		d := NewDriver("node-1", "")
+		d.Source = sourceBackup
+		configure(d)
+		if err := d.validateBackupSource(); err == nil || !strings.Contains(err.Error(), flag) {
+			t.Errorf("expected %s to be rejected with the backup source, got %v", flag, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// defaultSubnetSuffix is appended to the tenant name by Waldur when it creates the tenant's default subnet
+const defaultSubnetSuffix = "-sub-net"
+
+// listInternalSubnets returns the subnets of the offering's tenant which are not on external networks, sorted by name
+func (d *Driver) listInternalSubnets(client WaldurAPI) ([]waldurclient.OpenStackSubNet, error) {
+	tenantUuid, err := d.getTenantUuid(client)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	external := true
+	networks, err := listAll(d, "network listing", func(page, pageSize int) (int, []byte, *[]waldurclient.OpenStackNetwork, error) {
+		resp, err := client.OpenstackNetworksListWithResponse(ctx, &waldurclient.OpenstackNetworksListParams{
+			TenantUuid: &tenantUuid,
+			IsExternal: &external,
+			Page:       &page,
+			PageSize:   &pageSize,
+		})
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return resp.StatusCode(), resp.Body, resp.JSON200, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	externalNetworks := map[string]bool{}
+	for _, network := range networks {
+		if network.Url != nil && network.IsExternal != nil && *network.IsExternal {
+			externalNetworks[*network.Url] = true
+		}
+	}
+
+	tenantSubnets, err := listAll(d, "subnet listing", func(page, pageSize int) (int, []byte, *[]waldurclient.OpenStackSubNet, error) {
+		resp, err := client.OpenstackSubnetsListWithResponse(ctx, &waldurclient.OpenstackSubnetsListParams{
+			TenantUuid: &tenantUuid,
+			Page:       &page,
+			PageSize:   &pageSize,
+		})
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return resp.StatusCode(), resp.Body, resp.JSON200, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subnets := []waldurclient.OpenStackSubNet{}
+	for _, subnet := range tenantSubnets {
+		if subnet.Uuid == nil || subnet.Name == nil || (subnet.Network != nil && externalNetworks[*subnet.Network]) {
+			continue
+		}
+		subnets = append(subnets, subnet)
+	}
+	sort.Slice(subnets, func(i, j int) bool {
+		return *subnets[i].Name < *subnets[j].Name
+	})
+	return subnets, nil
+}
+
+// isDefaultSubnet reports whether the subnet is the default one Waldur created along with its tenant
+func isDefaultSubnet(subnet waldurclient.OpenStackSubNet) bool {
+	return subnet.TenantName != nil && *subnet.Name == *subnet.TenantName+defaultSubnetSuffix
+}
+
+// resolveSubnets selects the subnet of the instance when no subnets or ports are configured:
+// the tenant's only internal subnet, or else its default subnet. The selection is persisted.
+func (d *Driver) resolveSubnets(client WaldurAPI) error {
+	if len(d.portSpecs()) > 0 {
+		return nil
+	}
+
+	subnets, err := d.listInternalSubnets(client)
+	if err != nil {
+		return err
+	}
+	if len(subnets) == 0 {
+		return fmt.Errorf("tenant of offering %s has no internal subnets, please configure --waldur-subnet-uuids or --waldur-ports", d.OfferingUuid)
+	}
+
+	selected := -1
+	if len(subnets) == 1 {
+		selected = 0
+	} else {
+		for i, subnet := range subnets {
+			if isDefaultSubnet(subnet) {
+				selected = i
+				break
+			}
+		}
+	}
+	if selected < 0 {
+		candidates := make([]string, len(subnets))
+		for i, subnet := range subnets {
+			candidates[i] = fmt.Sprintf("%s (%s", *subnet.Name, subnet.Uuid)
+			if subnet.Cidr != nil {
+				candidates[i] += ", " + *subnet.Cidr
+			}
+			candidates[i] += ")"
+		}
+		return fmt.Errorf("unable to select a subnet automatically, please configure --waldur-subnet-uuids, candidates: %s", strings.Join(candidates, ", "))
+	}
+
+	subnet := subnets[selected]
+	d.SubnetUuids = []string{subnet.Uuid.String()}
+	log.Infof("Using subnet %s (%s) for instance %s", *subnet.Name, subnet.Uuid, d.GetMachineName())
+	return nil
+}
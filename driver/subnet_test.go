@@ -0,0 +1,82 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// addSubnet serves a subnet of the tenant on the network and returns its UUID
+func addSubnet(f *fakeWaldur, tenantUuid, name, network string) string {
+	subnetUuid := uuid.NewString()
+	f.AddListItem("openstack-subnets", map[string]any{
+		"uuid":        subnetUuid,
+		"name":        name,
+		"cidr":        "192.168.42.0/24",
+		"network":     network,
+		"tenant_uuid": tenantUuid,
+		"tenant_name": "dev",
+	})
+	return subnetUuid
+}
+
+func TestCreateSelectsOnlyInternalSubnet(t *testing.T) {
+	f := newFakeWaldur(t)
+	shortPages(t)
+	d := newTestDriver(t, f)
+	d.SubnetUuids = []string{}
+	tenantUuid := setServerGroupTenant(f, d)
+	f.AddListItem("openstack-networks", map[string]any{"url": "public-net", "is_external": true, "tenant_uuid": tenantUuid})
+	addSubnet(f, tenantUuid, "public-subnet", "public-net")
+	internal := addSubnet(f, tenantUuid, "k8s-subnet", "internal-net")
+	addSubnet(f, uuid.NewString(), "other-tenant-subnet", "other-net")
+
+	if err := d.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	ports := f.Orders[0]["attributes"].(map[string]any)["ports"].([]any)
+	if len(ports) != 1 || ports[0].(map[string]any)["subnet"] != f.URL()+"/api/openstack-subnets/"+internal+"/" {
+		t.Errorf("unexpected ports %v", ports)
+	}
+	if len(d.SubnetUuids) != 1 || d.SubnetUuids[0] != internal {
+		t.Errorf("selected subnet was not persisted: %v", d.SubnetUuids)
+	}
+}
+
+func TestPreCreateCheckSelectsDefaultSubnet(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.SubnetUuids = []string{}
+	tenantUuid := setServerGroupTenant(f, d)
+	addSubnet(f, tenantUuid, "k8s-subnet", "internal-net")
+	defaultSubnet := addSubnet(f, tenantUuid, "dev-sub-net", "dev-int-net")
+
+	if err := d.PreCreateCheck(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.SubnetUuids) != 1 || d.SubnetUuids[0] != defaultSubnet {
+		t.Errorf("expected the default subnet, got %v", d.SubnetUuids)
+	}
+}
+
+func TestPreCreateCheckListsSubnetCandidates(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	d.SubnetUuids = []string{}
+	tenantUuid := setServerGroupTenant(f, d)
+	first := addSubnet(f, tenantUuid, "k8s-subnet", "internal-net")
+	second := addSubnet(f, tenantUuid, "db-subnet", "internal-net")
+
+	err := d.PreCreateCheck()
+	if err == nil || !strings.Contains(err.Error(), "candidates: db-subnet ("+second) || !strings.Contains(err.Error(), "k8s-subnet ("+first) {
+		t.Fatalf("expected an error listing the candidates, got %v", err)
+	}
+
+	d = newTestDriver(t, f)
+	d.SubnetUuids = []string{}
+	setServerGroupTenant(f, d)
+	if err := d.PreCreateCheck(); err == nil || !strings.Contains(err.Error(), "has no internal subnets") {
+		t.Fatalf("expected an error for a tenant without subnets, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/mcnflag"
+)
+
+// UI field types, beyond the flag types the UI needs to tell secrets, long text and choices apart
+const (
+	UIFieldString    = "string"
+	UIFieldPassword  = "password"
+	UIFieldInt       = "int"
+	UIFieldBoolean   = "boolean"
+	UIFieldList      = "list"
+	UIFieldMultiline = "multiline"
+	UIFieldEnum      = "enum"
+)
+
+// UI field groups, in the order the UI shows them
+var UIGroups = []string{"Connection", "Placement", "Instance", "Storage", "Network", "Naming", "Lifecycle", "Import", "Advanced"}
+
+// UIField describes a driver flag to the Rancher UI
+type UIField struct {
+	Flag        string   `json:"flag"`
+	Field       string   `json:"field"`
+	Label       string   `json:"label"`
+	Type        string   `json:"type"`
+	Group       string   `json:"group"`
+	Description string   `json:"description"`
+	Default     any      `json:"default,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Choices     []string `json:"choices,omitempty"`
+	// Options is the inventory kind listing the valid values, served by the helper
+	Options string `json:"options,omitempty"`
+	// Credential fields belong in the Rancher cloud credential rather than the machine config
+	Credential bool `json:"credential,omitempty"`
+}
+
+// uiField is the UI metadata of a flag beyond its name, type, usage and default
+type uiField struct {
+	label      string
+	group      string
+	fieldType  string
+	required   bool
+	choices    []string
+	options    string
+	credential bool
+}
+
+// uiFields holds the UI metadata of every create flag
+var uiFields = map[string]uiField{
+	"waldur-api-url":                  {label: "API URL", group: "Connection", required: true, credential: true},
+	"waldur-api-token":                {label: "API token", group: "Connection", fieldType: UIFieldPassword, credential: true},
+	"waldur-api-token-file":           {label: "API token file", group: "Connection", credential: true},
+	"waldur-api-token-env":            {label: "API token variable", group: "Connection", credential: true},
+	"waldur-proj-uuid":                {label: "Project", group: "Placement", required: true, options: InventoryProjects},
+	"waldur-offering-uuid":            {label: "Offering", group: "Placement", required: true, options: InventoryOfferings},
+	"waldur-plan-uuid":                {label: "Plan", group: "Placement"},
+	"waldur-plan-name":                {label: "Plan name", group: "Placement"},
+	"waldur-limit":                    {label: "Component limits", group: "Placement"},
+	"waldur-availability-zone":        {label: "Availability zone", group: "Placement"},
+	"waldur-availability-zone-spread": {label: "Spread over availability zones", group: "Placement"},
+	"waldur-server-group":             {label: "Server group", group: "Placement"},
+	"waldur-server-group-auto":        {label: "Anti-affinity group per node pool", group: "Placement"},
+	"waldur-check-quotas":             {label: "Check quotas", group: "Placement"},
+	"waldur-flavor-uuid":              {label: "Flavor", group: "Instance", required: true, options: InventoryFlavors},
+	"waldur-source":                   {label: "Boot source", group: "Instance", fieldType: UIFieldEnum, choices: []string{sourceImage, sourceBackup}},
+	"waldur-image-uuid":               {label: "Image", group: "Instance", options: InventoryImages},
+	"waldur-source-uuid":              {label: "Backup", group: "Instance"},
+	"waldur-user-data":                {label: "User data", group: "Instance", fieldType: UIFieldMultiline},
+	"waldur-sys-volume-size":          {label: "System volume size (GB)", group: "Storage", required: true},
+	"waldur-sys-volume-type-uuid":     {label: "System volume type", group: "Storage", required: true, options: InventoryVolumeTypes},
+	"waldur-data-volume-type-uuid":    {label: "Data volume type", group: "Storage", required: true, options: InventoryVolumeTypes},
+	"waldur-subnet-uuids":             {label: "Subnets", group: "Network", options: InventorySubnets},
+	"waldur-ports":                    {label: "Ports", group: "Network", fieldType: UIFieldMultiline},
+	"waldur-sec-group-uuid":           {label: "Security group", group: "Network", options: InventorySecurityGroups},
+	"waldur-sec-group-uuids":          {label: "Additional security groups", group: "Network", options: InventorySecurityGroups},
+	"waldur-sec-group-auto":           {label: "Security group per cluster", group: "Network"},
+	"waldur-sec-group-cidr":           {label: "Node ports CIDR", group: "Network"},
+	"waldur-cluster-name":             {label: "Cluster name", group: "Naming"},
+	"waldur-node-pool":                {label: "Node pool", group: "Naming"},
+	"waldur-creator":                  {label: "Creator", group: "Naming"},
+	"waldur-name-template":            {label: "Name template", group: "Naming"},
+	"waldur-name-unique":              {label: "Unique names", group: "Naming"},
+	"waldur-stop-mode":                {label: "Stop mode", group: "Lifecycle", fieldType: UIFieldEnum, choices: []string{stopModeStop, stopModeShelve, stopModeSuspend}},
+	"waldur-snapshot-on-remove":       {label: "Preserve on removal", group: "Lifecycle", fieldType: UIFieldEnum, choices: []string{"", snapshotModeSnapshot, snapshotModeBackup}},
+	"waldur-import-resource-uuid":     {label: "Resource to adopt", group: "Import"},
+	"waldur-import-ssh-key-path":      {label: "SSH key of the adopted instance", group: "Import"},
+	"waldur-import-ssh-user":          {label: "SSH user of the adopted instance", group: "Import"},
+	"waldur-order-attributes":         {label: "Extra order attributes", group: "Advanced", fieldType: UIFieldMultiline},
+}
+
+// ConfigFieldName converts a driver flag to the field name Rancher uses in machine
+// configs and node templates, e.g. waldur-proj-uuid to projUuid
+func ConfigFieldName(flag string) string {
+	parts := strings.Split(strings.TrimPrefix(flag, "waldur-"), "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// flagType returns the UI type matching the type of the flag
+func flagType(flag mcnflag.Flag) string {
+	switch flag.(type) {
+	case mcnflag.IntFlag:
+		return UIFieldInt
+	case mcnflag.BoolFlag:
+		return UIFieldBoolean
+	case mcnflag.StringSliceFlag:
+		return UIFieldList
+	}
+	return UIFieldString
+}
+
+// UIFields describes the create flags to the Rancher UI, ordered by group
+func (d *Driver) UIFields() []UIField {
+	fields := []UIField{}
+	for _, flag := range d.GetCreateFlags() {
+		meta, ok := uiFields[flag.String()]
+		if !ok {
+			meta = uiField{label: flag.String(), group: "Advanced"}
+		}
+		field := UIField{
+			Flag:       flag.String(),
+			Field:      ConfigFieldName(flag.String()),
+			Label:      meta.label,
+			Type:       meta.fieldType,
+			Group:      meta.group,
+			Required:   meta.required,
+			Choices:    meta.choices,
+			Options:    meta.options,
+			Credential: meta.credential,
+		}
+		if field.Type == "" {
+			field.Type = flagType(flag)
+		}
+		switch typed := flag.(type) {
+		case mcnflag.StringFlag:
+			field.Description = typed.Usage
+			if typed.Value != "" {
+				field.Default = typed.Value
+			}
+		case mcnflag.IntFlag:
+			field.Description = typed.Usage
+			if typed.Value != 0 {
+				field.Default = typed.Value
+			}
+		case mcnflag.BoolFlag:
+			field.Description = typed.Usage
+		case mcnflag.StringSliceFlag:
+			field.Description = typed.Usage
+			if len(typed.Value) > 0 {
+				field.Default = typed.Value
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	groupIndex := map[string]int{}
+	for i, group := range UIGroups {
+		groupIndex[group] = i
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return groupIndex[fields[i].Group] < groupIndex[fields[j].Group]
+	})
+	return fields
+}
+
+// NodeDriverAnnotations returns the annotations of the Rancher NodeDriver resource telling
+// the UI which fields come from the cloud credential, which are secret and their defaults
+func (d *Driver) NodeDriverAnnotations() map[string]string {
+	public, private, optional, passwords, defaults := []string{}, []string{}, []string{}, []string{}, []string{}
+	for _, field := range d.UIFields() {
+		if field.Credential {
+			if field.Type == UIFieldPassword {
+				private = append(private, field.Field)
+			} else {
+				public = append(public, field.Field)
+			}
+			if !field.Required {
+				optional = append(optional, field.Field)
+			}
+		}
+		if field.Type == UIFieldPassword {
+			passwords = append(passwords, field.Field)
+		}
+		if field.Default != nil {
+			defaults = append(defaults, fmt.Sprintf("%s:%v", field.Field, field.Default))
+		}
+	}
+	return map[string]string{
+		"publicCredentialFields":   strings.Join(public, ","),
+		"privateCredentialFields":  strings.Join(private, ","),
+		"optionalCredentialFields": strings.Join(optional, ","),
+		"passwordFields":           strings.Join(passwords, ","),
+		"defaults":                 strings.Join(defaults, ","),
+	}
+}
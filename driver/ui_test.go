@@ -0,0 +1,73 @@
+package driver
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestUIFieldsCoverCreateFlags(t *testing.T) {
+	d := NewDriver("", "")
+	for _, flag := range d.GetCreateFlags() {
+		if _, ok := uiFields[flag.String()]; !ok {
+			t.Errorf("flag %s has no UI metadata", flag.String())
+		}
+	}
+	for name, meta := range uiFields {
+		if !slices.Contains(UIGroups, meta.group) {
+			t.Errorf("flag %s is in the unknown group %q", name, meta.group)
+		}
+	}
+}
+
+func TestUIFields(t *testing.T) {
+	fields := NewDriver("", "").UIFields()
+	byFlag := map[string]UIField{}
+	for _, field := range fields {
+		byFlag[field.Flag] = field
+	}
+
+	token := byFlag["waldur-api-token"]
+	if token.Type != UIFieldPassword || !token.Credential || token.Field != "apiToken" || token.Description != "Waldur API token" {
+		t.Errorf("unexpected token field %+v", token)
+	}
+	if size := byFlag["waldur-sys-volume-size"]; size.Type != UIFieldInt || !size.Required {
+		t.Errorf("unexpected volume size field %+v", size)
+	}
+	if subnets := byFlag["waldur-subnet-uuids"]; subnets.Type != UIFieldList || subnets.Options != InventorySubnets {
+		t.Errorf("unexpected subnets field %+v", subnets)
+	}
+	if stop := byFlag["waldur-stop-mode"]; stop.Type != UIFieldEnum || stop.Default != stopModeStop || len(stop.Choices) != 3 {
+		t.Errorf("unexpected stop mode field %+v", stop)
+	}
+	if fields[0].Group != "Connection" || fields[len(fields)-1].Group != "Advanced" {
+		t.Errorf("fields are not ordered by group: first %s, last %s", fields[0].Group, fields[len(fields)-1].Group)
+	}
+}
+
+func TestNodeDriverAnnotations(t *testing.T) {
+	annotations := NewDriver("", "").NodeDriverAnnotations()
+	expected := map[string]string{
+		"publicCredentialFields":   "apiUrl,apiTokenFile,apiTokenEnv",
+		"privateCredentialFields":  "apiToken",
+		"optionalCredentialFields": "apiToken,apiTokenFile,apiTokenEnv",
+		"passwordFields":           "apiToken",
+		"defaults":                 "source:image,stopMode:stop",
+	}
+	for key, value := range expected {
+		if annotations[key] != value {
+			t.Errorf("annotation %s is %q, expected %q", key, annotations[key], value)
+		}
+	}
+}
+
+func TestConfigFieldName(t *testing.T) {
+	for flag, expected := range map[string]string{
+		"waldur-proj-uuid":             "projUuid",
+		"waldur-data-volume-type-uuid": "dataVolumeTypeUuid",
+		"waldur-ports":                 "ports",
+	} {
+		if name := ConfigFieldName(flag); name != expected {
+			t.Errorf("%s: expected %s, got %s", flag, expected, name)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/ssh"
+	waldurclient "github.com/waldur/go-client"
+)
+
+// Volume kinds accepted by ExtendVolume
+const (
+	VolumeSystem = "system"
+	VolumeData   = "data"
+)
+
+// runSSHCommand runs a command on the machine with its SSH key, tests replace it to avoid SSH
+var runSSHCommand = func(d *Driver, command string) (string, error) {
+	return runSSHCommandWithKey(d, d.GetSSHKeyPath(), command)
+}
+
+// runSSHCommandWithKey runs a command on the machine with the given SSH key, tests replace it to avoid SSH
+var runSSHCommandWithKey = func(d *Driver, keyPath, command string) (string, error) {
+	host, err := d.GetSSHHostname()
+	if err != nil {
+		return "", err
+	}
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return "", err
+	}
+	client, err := ssh.NewClient(d.GetSSHUsername(), host, port, &ssh.Auth{Keys: []string{keyPath}})
+	if err != nil {
+		return "", err
+	}
+	return client.Output(command)
+}
+
+// waitForSSH polls the machine until it accepts SSH commands
+func (d *Driver) waitForSSH() error {
+	deadline := time.Now().Add(operationPollTimeout)
+	for {
+		_, err := runSSHCommand(d, "exit 0")
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for SSH on %s after %v: %w", d.GetMachineName(), operationPollTimeout, err)
+		}
+		log.Infof("Waiting for SSH on %s...", d.GetMachineName())
+		time.Sleep(operationPollInterval)
+	}
+}
+
+// resolveDataDiskScript sets TARGET to the mounted filesystem of the data volume's disk. The
+// guest may name the disk differently from Waldur, e.g. sdb instead of vdb, so the disk is found
+// by its serial, the Cinder volume ID (Waldur's backend ID) truncated by virtio to 20 characters,
+// or else by its size.
+const resolveDataDiskScript = `VOLUME='%s'
+SIZE=%d
+DISK=$(lsblk -dnpo NAME,SERIAL | awk -v volume="$VOLUME" 'length($2) >= 20 && index(volume, $2) == 1 {print $1; exit}')
+if [ -z "$DISK" ]; then
+  DISK=$(lsblk -dbnpo NAME,SIZE,TYPE | awk -v size="$SIZE" '$2 == size && $3 == "disk" {print $1}')
+  if [ "$(echo "$DISK" | grep -c .)" -ne 1 ]; then
+    echo "unable to find the disk of volume $VOLUME" >&2
+    exit 1
+  fi
+fi
+TARGET=$(lsblk -lnpo NAME,MOUNTPOINT "$DISK" | awk '$2 != "" {print $1; exit}')
+if [ -z "$TARGET" ]; then
+  echo "$DISK of volume $VOLUME is not mounted" >&2
+  exit 1
+fi
+`
+
+// growFilesystemScript grows the partition and filesystem mounted from or at the target
+// to fill its volume. growpart exits with 1 when the partition already fills the disk.
+const growFilesystemScript = `set -e
+%s
+SOURCE=$(findmnt -n -o SOURCE "$TARGET" | head -n 1)
+FSTYPE=$(findmnt -n -o FSTYPE "$TARGET" | head -n 1)
+if [ -z "$SOURCE" ]; then
+  echo "$TARGET is not mounted" >&2
+  exit 1
+fi
+PARENT=$(lsblk -no PKNAME "$SOURCE" | head -n 1)
+if [ -n "$PARENT" ] && [ -f "/sys/class/block/$(basename "$SOURCE")/partition" ]; then
+  sudo growpart "/dev/$PARENT" "$(cat "/sys/class/block/$(basename "$SOURCE")/partition")" || [ $? -eq 1 ]
+fi
+case "$FSTYPE" in
+  xfs) sudo xfs_growfs "$(findmnt -n -o TARGET "$SOURCE" | head -n 1)" ;;
+  ext2|ext3|ext4) sudo resize2fs "$SOURCE" ;;
+  *) echo "unsupported filesystem $FSTYPE on $SOURCE" >&2; exit 1 ;;
+esac
+`
+
+// findVolume returns the instance's bootable volume for the system kind, or its first other volume for the data kind
+func (d *Driver) findVolume(instance *waldurclient.OpenStackInstance, kind string) (*waldurclient.OpenStackNestedVolume, error) {
+	if kind != VolumeSystem && kind != VolumeData {
+		return nil, fmt.Errorf("invalid volume %q, expected %s or %s", kind, VolumeSystem, VolumeData)
+	}
+	if instance.Volumes != nil {
+		for i, volume := range *instance.Volumes {
+			bootable := volume.Bootable != nil && *volume.Bootable
+			if bootable == (kind == VolumeSystem) && volume.Uuid != nil {
+				return &(*instance.Volumes)[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("instance %s has no %s volume", d.GetMachineName(), kind)
+}
+
+// getVolume retrieves the OpenStack volume of an instance
+func (d *Driver) getVolume(client WaldurAPI, volume *waldurclient.OpenStackNestedVolume) (*waldurclient.OpenStackVolume, error) {
+	resp, err := client.OpenstackVolumesRetrieveWithResponse(context.Background(), *volume.Uuid, &waldurclient.OpenstackVolumesRetrieveParams{})
+	if err != nil {
+		log.Errorf("Error calling volume retrieval API: %v", err)
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, d.apiError("volume retrieval", "", resp.StatusCode(), resp.Body)
+	}
+	return resp.JSON200, nil
+}
+
+// waitForVolume polls the Waldur API until the volume is usable with at least the given size in MB
+func (d *Driver) waitForVolume(client WaldurAPI, volume *waldurclient.OpenStackNestedVolume, sizeMB int) error {
+	deadline := time.Now().Add(operationPollTimeout)
+	for {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for volume %s of %s to be extended after %v", volume.Uuid, d.GetMachineName(), operationPollTimeout)
+		}
+
+		current, err := d.getVolume(client, volume)
+		if err != nil {
+			return err
+		}
+		if current.State != nil {
+			switch string(*current.State) {
+			case "OK":
+				if current.Size != nil && *current.Size >= sizeMB {
+					return nil
+				}
+			case "Erred":
+				errMsg := ""
+				if current.ErrorMessage != nil {
+					errMsg = *current.ErrorMessage
+				}
+				return fmt.Errorf("extension of volume %s of %s failed: %s", volume.Uuid, d.GetMachineName(), errMsg)
+			}
+		}
+
+		log.Infof("Waiting for volume %s of %s to be extended...", volume.Uuid, d.GetMachineName())
+		time.Sleep(operationPollInterval)
+	}
+}
+
+// extendVolume requests the extension of the volume to the size in MB and waits for completion
+func (d *Driver) extendVolume(client WaldurAPI, volume *waldurclient.OpenStackNestedVolume, sizeMB int) (err error) {
+	start := time.Now()
+	defer func() {
+		d.observe("volume extension", time.Since(start), err)
+	}()
+
+	ctx := context.Background()
+	resp, err := client.OpenstackVolumesExtendWithResponse(ctx, *volume.Uuid, waldurclient.OpenstackVolumesExtendJSONRequestBody{
+		DiskSize: sizeMB,
+	})
+	if err != nil {
+		log.Errorf("Error calling volume extension API: %v", err)
+		return err
+	}
+	if resp.StatusCode() != 202 {
+		return d.apiError("volume extension", d.ResourceUuid, resp.StatusCode(), resp.Body)
+	}
+	return d.waitForVolume(client, volume, sizeMB)
+}
+
+// growFilesystem grows the filesystem of the volume with the size in MB over SSH
+func (d *Driver) growFilesystem(client WaldurAPI, kind string, volume *waldurclient.OpenStackNestedVolume, sizeMB int) error {
+	target := "/"
+	resolve := "TARGET='/'"
+	if kind == VolumeData {
+		current, err := d.getVolume(client, volume)
+		if err != nil {
+			return err
+		}
+		if current.BackendId == nil || *current.BackendId == "" {
+			return fmt.Errorf("data volume %s of %s has no backend ID to find its disk", volume.Uuid, d.GetMachineName())
+		}
+		target = fmt.Sprintf("data volume %s", volume.Uuid)
+		resolve = fmt.Sprintf(resolveDataDiskScript, *current.BackendId, sizeMB*1024*1024)
+	}
+	output, err := runSSHCommand(d, fmt.Sprintf(growFilesystemScript, resolve))
+	if err != nil {
+		return fmt.Errorf("failed to grow filesystem on %s of %s: %w, output: %s", target, d.GetMachineName(), err, output)
+	}
+	log.Infof("Grew filesystem on %s of %s", target, d.GetMachineName())
+	return nil
+}
+
+// ExtendVolume grows the system or data volume of the instance to the size in GB and its
+// filesystem over SSH. A running instance is stopped for the extension and started again.
+// The new size is persisted in SystemVolumeSize or DataVolumeSize.
+func (d *Driver) ExtendVolume(kind string, sizeGB int) error {
+	client, err := d.getWaldurClient()
+	if err != nil {
+		log.Errorf("Error creating Waldur client %s", err)
+		return err
+	}
+	instance, err := d.getWaldurInstance(client)
+	if err != nil {
+		return err
+	}
+	volume, err := d.findVolume(instance, kind)
+	if err != nil {
+		return err
+	}
+
+	sizeMB := sizeGB * 1024
+	if volume.Size != nil && *volume.Size > sizeMB {
+		return fmt.Errorf("%s volume of %s has %d GB, volumes can only grow", kind, d.GetMachineName(), *volume.Size/1024)
+	}
+
+	running := instance.RuntimeState != nil && *instance.RuntimeState == "ACTIVE"
+	// An extended volume whose filesystem was not grown yet only needs the SSH step
+	if volume.Size == nil || *volume.Size < sizeMB {
+		if running {
+			if err := d.runOperation(stopOperation(stopModeStop)); err != nil {
+				return err
+			}
+		}
+		if err := d.extendVolume(client, volume, sizeMB); err != nil {
+			return err
+		}
+		log.Infof("Extended %s volume of %s to %d GB", kind, d.GetMachineName(), sizeGB)
+		if running {
+			if err := d.runOperation(startOperation("SHUTOFF")); err != nil {
+				return err
+			}
+		}
+	}
+	if kind == VolumeSystem {
+		d.SystemVolumeSize = sizeGB
+	} else {
+		d.DataVolumeSize = sizeGB
+	}
+
+	if !running {
+		log.Warnf("Instance %s is not running, extend its %s volume again once it runs to grow the filesystem", d.GetMachineName(), kind)
+		return nil
+	}
+	if err := d.waitForSSH(); err != nil {
+		return err
+	}
+	return d.growFilesystem(client, kind, volume, sizeMB)
+}
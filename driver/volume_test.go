@@ -0,0 +1,107 @@
+package driver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recordSSH replaces SSH with a recorder of the commands run on the machine
+func recordSSH(t *testing.T, err error) *[]string {
+	commands := []string{}
+	original := runSSHCommand
+	runSSHCommand = func(d *Driver, command string) (string, error) {
+		commands = append(commands, command)
+		return "", err
+	}
+	t.Cleanup(func() {
+		runSSHCommand = original
+	})
+	return &commands
+}
+
+func TestExtendSystemVolumeOfRunningInstance(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	resource := f.AddResource("node-1", "ACTIVE")
+	volume := f.AddVolume(resource, true, 20*1024, "/dev/vda")
+	f.AddVolume(resource, false, 10*1024, "/dev/vdb")
+	d.ResourceUuid = resource.Uuid
+	commands := recordSSH(t, nil)
+
+	if err := d.ExtendVolume(VolumeSystem, 40); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(f.Actions, ",") != "stop,extend,start" {
+		t.Errorf("unexpected actions %v", f.Actions)
+	}
+	if volume.Size != 40*1024 || d.SystemVolumeSize != 40 {
+		t.Errorf("unexpected sizes, volume %d MB, persisted %d GB", volume.Size, d.SystemVolumeSize)
+	}
+	if len(*commands) != 2 || !strings.Contains((*commands)[1], "TARGET='/'") || !strings.Contains((*commands)[1], "growpart") {
+		t.Errorf("unexpected SSH commands %v", *commands)
+	}
+}
+
+func TestExtendDataVolumeOfStoppedInstance(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	resource := f.AddResource("node-1", "SHUTOFF")
+	f.AddVolume(resource, true, 20*1024, "/dev/vda")
+	volume := f.AddVolume(resource, false, 10*1024, "/dev/vdb")
+	d.ResourceUuid = resource.Uuid
+	commands := recordSSH(t, nil)
+
+	if err := d.ExtendVolume(VolumeData, 50); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(f.Actions, ",") != "extend" || volume.Size != 50*1024 || d.DataVolumeSize != 50 {
+		t.Errorf("unexpected extension, actions %v, size %d MB", f.Actions, volume.Size)
+	}
+	if len(*commands) != 0 {
+		t.Errorf("SSH was used on a stopped instance: %v", *commands)
+	}
+}
+
+func TestExtendVolumeOnlyGrowsFilesystemWhenAlreadyExtended(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	resource := f.AddResource("node-1", "ACTIVE")
+	// The guest names the disk independently of Waldur, so it is found by the Cinder ID in its serial or by size
+	volume := f.AddVolume(resource, false, 50*1024, "/dev/vdb")
+	d.ResourceUuid = resource.Uuid
+	commands := recordSSH(t, nil)
+
+	if err := d.ExtendVolume(VolumeData, 50); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Actions) != 0 {
+		t.Errorf("unexpected actions %v", f.Actions)
+	}
+	if len(*commands) != 2 {
+		t.Fatalf("unexpected SSH commands %v", *commands)
+	}
+	script := (*commands)[1]
+	if !strings.Contains(script, "VOLUME='"+volume.BackendId+"'") || strings.Contains(script, volume.Uuid) || !strings.Contains(script, "SIZE=53687091200") || strings.Contains(script, "/dev/vdb") {
+		t.Errorf("unexpected data disk resolution %s", script)
+	}
+}
+
+func TestExtendVolumeErrors(t *testing.T) {
+	f := newFakeWaldur(t)
+	d := newTestDriver(t, f)
+	resource := f.AddResource("node-1", "ACTIVE")
+	f.AddVolume(resource, true, 20*1024, "/dev/vda")
+	d.ResourceUuid = resource.Uuid
+	recordSSH(t, errors.New("no route to host"))
+
+	for kind, expected := range map[string]string{
+		VolumeSystem: "volumes can only grow",
+		VolumeData:   "has no data volume",
+		"swap":       "invalid volume",
+	} {
+		if err := d.ExtendVolume(kind, 10); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected %q error, got %v", kind, expected, err)
+		}
+	}
+}
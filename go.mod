@@ -8,6 +8,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/rancher/machine v0.16.2
 	github.com/waldur/go-client v0.0.0-20260401113022-40a829c05f95
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"os"
+
 	"github.com/rancher/machine/libmachine/drivers/plugin"
+	"github.com/rancher/machine/libmachine/drivers/plugin/localbinary"
+	"github.com/waldur/waldur-rancher-node-driver/cli"
 	"github.com/waldur/waldur-rancher-node-driver/driver"
 )
 
 func main() {
+	// rancher-machine sets the plugin token when it launches the driver,
+	// otherwise the binary runs the companion commands
+	if len(os.Args) > 1 && os.Getenv(localbinary.PluginEnvKey) != localbinary.PluginEnvVal {
+		os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+	}
 	plugin.RegisterDriver(driver.NewDriver("", ""))
 }